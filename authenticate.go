@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Reading chirps and profiles needs no credentials, so there is no read
+// scope for chirps.
+const (
+	scopeChirpsWrite  = "chirps:write"
+	scopeAccountRead  = "account:read"
+	scopeAccountWrite = "account:write"
+
+	// scopeWebhooksWrite lets an app register and manage webhook
+	// endpoints without access to the rest of the account.
+	scopeWebhooksWrite = "webhooks:write"
+
+	// scopeFirstParty marks endpoints that only first-party tokens may
+	// call, such as managing the API keys themselves.
+	scopeFirstParty = ""
+)
+
+var oauthScopes = []string{scopeChirpsWrite, scopeWebhooksWrite}
+
+// defaultOAuthScopes are granted to clients registered without any.
+var defaultOAuthScopes = []string{scopeChirpsWrite}
+
+var apiKeyScopes = []string{scopeChirpsWrite, scopeAccountRead, scopeAccountWrite, scopeWebhooksWrite}
+
+var (
+	errAuthMissing       = errors.New("authorization header missing")
+	errInsufficientScope = errors.New("credentials do not grant the required scope")
+	errSessionRevoked    = errors.New("session has been revoked")
+)
+
+// authenticate returns the user behind the request's Authorization header.
+// First-party bearer tokens have full access. OAuth access tokens and
+// personal API keys only pass when they were granted scope.
+func (cfg *apiConfig) authenticate(r *http.Request, scope string) (uuid.UUID, error) {
+	userID, err := cfg.authenticateRequest(r, scope)
+	if err == nil {
+		setRequestUser(r.Context(), userID)
+	}
+	return userID, err
+}
+
+func (cfg *apiConfig) authenticateRequest(r *http.Request, scope string) (uuid.UUID, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return uuid.Nil, errAuthMissing
+	}
+
+	if strings.HasPrefix(header, "ApiKey ") {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return cfg.authenticateAPIKey(r.Context(), key, scope)
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if claims, err := auth.ValidateJWTClaims(token, cfg.secretKey); err == nil {
+		if err := cfg.checkSession(r.Context(), claims.UserID, claims.SessionID); err != nil {
+			return uuid.Nil, err
+		}
+		return claims.UserID, nil
+	}
+
+	claims, err := auth.ValidateOAuthJWT(token, cfg.secretKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if scope == scopeFirstParty || !claims.HasScope(scope) {
+		return uuid.Nil, errInsufficientScope
+	}
+	if err := cfg.checkSession(r.Context(), claims.UserID, claims.SessionID); err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// checkSession rejects access tokens whose session has been revoked or
+// has expired. Tokens issued before access tokens named their session
+// carry uuid.Nil and are accepted until they expire.
+func (cfg *apiConfig) checkSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	if sessionID == uuid.Nil {
+		return nil
+	}
+	active, err := cfg.db.IsSessionActive(ctx, database.IsSessionActiveParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+	if !active {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// requestSessionID returns the session behind the request's access token,
+// or uuid.Nil for API keys and tokens that don't name one. It assumes the
+// request has already been authenticated.
+func (cfg *apiConfig) requestSessionID(r *http.Request) uuid.UUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil
+	}
+	if claims, err := auth.ValidateJWTClaims(token, cfg.secretKey); err == nil {
+		return claims.SessionID
+	}
+	if claims, err := auth.ValidateOAuthJWT(token, cfg.secretKey); err == nil {
+		return claims.SessionID
+	}
+	return uuid.Nil
+}
+
+func (cfg *apiConfig) authenticateAPIKey(ctx context.Context, key, scope string) (uuid.UUID, error) {
+	selector, secret, err := auth.SplitAPIKey(key)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	apiKey, err := cfg.db.GetAPIKeyBySelector(ctx, selector)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !auth.CheckTokenHash(secret, apiKey.KeyHash) || apiKey.RevokedAt.Valid {
+		return uuid.Nil, errors.New("invalid API key")
+	}
+	if scope == scopeFirstParty || !slices.Contains(strings.Fields(apiKey.Scopes), scope) {
+		return uuid.Nil, errInsufficientScope
+	}
+
+	if err := cfg.db.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		slog.ErrorContext(ctx, "recording API key use", "error", err)
+	}
+	return apiKey.UserID, nil
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errAuthMissing):
+		respondWithError(w, http.StatusUnauthorized, "Authorization token missing", err)
+	case errors.Is(err, errInsufficientScope):
+		respondWithError(w, http.StatusForbidden, "Credentials do not grant the required scope", err)
+	case errors.Is(err, errPermissionDenied):
+		respondWithError(w, http.StatusForbidden, "You do not have permission to perform this action", err)
+	default:
+		respondWithError(w, http.StatusUnauthorized, "Invalid authorization token", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/entitlements"
+	"net/http"
+)
+
+// limitsFor looks up the user's current tier. It reads the subscription
+// state from the database rather than the token so upgrades apply at once.
+func (cfg *apiConfig) limitsFor(ctx context.Context, userID uuid.UUID) (string, entitlements.Limits, error) {
+	dbUser, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", entitlements.Limits{}, err
+	}
+	tier := entitlements.TierFor(dbUser.IsChirpyRed)
+	return tier, cfg.tiers.For(tier), nil
+}
+
+func (cfg *apiConfig) handlerEntitlements(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tier, limits, err := cfg.limitsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the entitlements", err)
+		return
+	}
+
+	type response struct {
+		Tier   string              `json:"tier"`
+		Limits entitlements.Limits `json:"limits"`
+	}
+	respondWithJSON(w, http.StatusOK, response{Tier: tier, Limits: limits})
+}
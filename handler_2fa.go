@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"log/slog"
+	"net/http"
+)
+
+const recoveryCodeCount = 10
+
+func (cfg *apiConfig) handler2FAEnroll(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the TOTP secret", err)
+		return
+	}
+
+	err = cfg.db.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the TOTP secret", err)
+		return
+	}
+
+	type enrollResponse struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	respondWithJSON(w, http.StatusOK, enrollResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, "Chirpy", dbUser.Email),
+	})
+}
+
+func (cfg *apiConfig) handler2FAVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	if dbUser.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	if !dbUser.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment has not been started", nil)
+		return
+	}
+
+	if !cfg.checkTOTP(r.Context(), dbUser, params.Code) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the recovery codes", err)
+		return
+	}
+
+	err = cfg.db.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the recovery codes", err)
+		return
+	}
+
+	for _, code := range codes {
+		err = cfg.db.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the recovery codes", err)
+			return
+		}
+	}
+
+	err = cfg.db.EnableTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong enabling two-factor authentication", err)
+		return
+	}
+
+	type verifyResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	respondWithJSON(w, http.StatusOK, verifyResponse{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handler2FADisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	if !dbUser.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	if !cfg.checkTOTP(r.Context(), dbUser, params.Code) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code", nil)
+		return
+	}
+
+	err = cfg.db.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong disabling two-factor authentication", err)
+		return
+	}
+
+	err = cfg.db.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong deleting the recovery codes", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLogin2FA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	userID, err := auth.ValidateChallengeJWT(params.ChallengeToken, cfg.secretKey)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token", err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil || !dbUser.TotpEnabled {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token", err)
+		return
+	}
+
+	if !cfg.loginAllowed(w, r, dbUser.Email) {
+		return
+	}
+	failedUser := uuid.NullUUID{UUID: dbUser.ID, Valid: true}
+
+	switch {
+	case params.Code != "":
+		if !cfg.checkTOTP(r.Context(), dbUser, params.Code) {
+			cfg.recordLoginFailure(r, dbUser.Email, failedUser)
+			respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code", nil)
+			return
+		}
+	case params.RecoveryCode != "":
+		used, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode)),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong checking the recovery code", err)
+			return
+		}
+		if used == 0 {
+			cfg.recordLoginFailure(r, dbUser.Email, failedUser)
+			respondWithError(w, http.StatusUnauthorized, "Invalid recovery code", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "A two-factor code or recovery code is required", nil)
+		return
+	}
+
+	err = cfg.accountLimiter.Reset(r.Context(), accountLockoutKey(dbUser.Email))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resetting login failures", "error", err)
+	}
+
+	cfg.respondWithSession(w, r, dbUser)
+}
+
+// checkTOTP validates code against the user's secret and records the
+// matching step so the same code cannot be replayed.
+func (cfg *apiConfig) checkTOTP(ctx context.Context, dbUser database.User, code string) bool {
+	if !dbUser.TotpSecret.Valid {
+		return false
+	}
+
+	counter, ok := auth.ValidateTOTP(dbUser.TotpSecret.String, code, cfg.now())
+	if !ok {
+		return false
+	}
+
+	updated, err := cfg.db.UpdateTOTPCounter(ctx, database.UpdateTOTPCounterParams{
+		ID:              dbUser.ID,
+		TotpLastCounter: counter,
+	})
+	return err == nil && updated == 1
+}
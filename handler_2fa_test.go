@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/lockout"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTwoFactorFlow enrolls, verifies and logs in with a second factor
+// against a fixed clock, so the codes don't depend on when it runs.
+func TestTwoFactorFlow(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	store := lockout.NewMemoryStore()
+	cfg.accountLimiter = lockout.NewLimiter(store, lockout.AccountPolicy)
+	cfg.ipLimiter = lockout.NewLimiter(store, lockout.IPPolicy)
+	now := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	cfg.now = func() time.Time { return now }
+
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", PasswordHash: hash}
+
+	// Enroll.
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "SetTOTPSecret").WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/2fa/enroll", nil)
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	cfg.handler2FAEnroll(rec, req)
+	var enrolled struct {
+		Secret string `json:"secret"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&enrolled) != nil || enrolled.Secret == "" {
+		t.Fatalf("Expected a TOTP secret, got %d %s", rec.Code, rec.Body)
+	}
+	user.TotpSecret = sql.NullString{String: enrolled.Secret, Valid: true}
+
+	// Verify with the code for the current step.
+	code, err := auth.GenerateTOTP(enrolled.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "UpdateTOTPCounter").WithArgs(user.ID, now.Unix()/30).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectExec(mock, "DeleteRecoveryCodes").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	for range recoveryCodeCount {
+		expectExec(mock, "CreateRecoveryCode").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectExec(mock, "EnableTOTP").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/2fa/verify", strings.NewReader(`{"code":"`+code+`"}`))
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	cfg.handler2FAVerify(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "recovery_codes") {
+		t.Fatalf("Expected 2FA to be enabled, got %d %s", rec.Code, rec.Body)
+	}
+	user.TotpEnabled = true
+	user.TotpLastCounter = now.Unix() / 30
+
+	// The password alone only earns a challenge token.
+	expectQuery(mock, "GetUser").WithArgs(user.Email).WillReturnRows(modelRows(user))
+	rec = httptest.NewRecorder()
+	cfg.handlerLogin(rec, httptest.NewRequest(http.MethodPost, "/api/login",
+		strings.NewReader(`{"email":"saul@bettercall.com","password":"correct horse battery staple"}`)))
+	var challenge struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&challenge) != nil || !challenge.TwoFactorRequired {
+		t.Fatalf("Expected a two-factor challenge, got %d %s", rec.Code, rec.Body)
+	}
+
+	login2FA := func(code string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.handlerLogin2FA(rec, httptest.NewRequest(http.MethodPost, "/api/login/2fa",
+			strings.NewReader(`{"challenge_token":"`+challenge.ChallengeToken+`","code":"`+code+`"}`)))
+		return rec
+	}
+
+	// The code used for verification can't be replayed.
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "UpdateTOTPCounter").WithArgs(user.ID, now.Unix()/30).WillReturnResult(sqlmock.NewResult(0, 0))
+	if rec := login2FA(code); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected a replayed code to be refused, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Thirty seconds later the next code completes the login.
+	now = now.Add(30 * time.Second)
+	code, err = auth.GenerateTOTP(enrolled.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := database.RefreshToken{ID: uuid.New(), UserID: user.ID}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "UpdateTOTPCounter").WithArgs(user.ID, now.Unix()/30).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectQuery(mock, "CreateRefreshToken").WillReturnRows(modelRows(session))
+	rec = login2FA(code)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"token"`) {
+		t.Fatalf("Expected tokens, got %d %s", rec.Code, rec.Body)
+	}
+}
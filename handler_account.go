@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/mailer"
+	"github.com/mr_rambling/chirpy/internal/tracing"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	tokenPurposePasswordReset     = "password_reset"
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposeEmailChange       = "email_change"
+	tokenPurposeAccountDeletion   = "account_deletion"
+)
+
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	// Always answer the same way, and before looking anything up, so
+	// neither the response nor its timing shows which emails are
+	// registered.
+	cfg.runInBackground(r.Context(), "sending password reset email", func(ctx context.Context) error {
+		dbUser, err := cfg.db.GetUser(ctx, params.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return cfg.sendUserToken(ctx, dbUser.ID, dbUser.Email, tokenPurposePasswordReset, "", time.Hour,
+			"Reset your Chirpy password",
+			"Someone asked to reset the password for your Chirpy account.\n\n"+
+				"Use this link within the next hour to choose a new one:\n%s\n\n"+
+				"If this wasn't you, you can ignore this email.",
+			"/app/reset-password")
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	// Check the policy before consuming the token so a rejected password
+	// doesn't burn the reset link.
+	pending, err := cfg.db.GetUserToken(r.Context(), database.GetUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposePasswordReset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	dbUser, err := cfg.db.GetUserByID(r.Context(), pending.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+	if !cfg.checkPassword(w, params.Password, dbUser.Email) {
+		return
+	}
+
+	userToken, err := cfg.db.ConsumeUserToken(r.Context(), database.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposePasswordReset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+
+	hashedPw, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong hashing the password", err)
+		return
+	}
+
+	err = cfg.db.UpdatePassword(r.Context(), database.UpdatePasswordParams{
+		ID:           userToken.UserID,
+		PasswordHash: hashedPw,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the password", err)
+		return
+	}
+
+	err = cfg.db.RevokeAllSessions(r.Context(), userToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerEmailVerifyRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	if dbUser.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email address is already verified", nil)
+		return
+	}
+
+	err = cfg.sendEmailVerification(r.Context(), dbUser)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong sending the verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	userToken, err := cfg.db.ConsumeUserToken(r.Context(), database.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposeEmailVerification,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+
+	err = cfg.db.SetEmailVerified(r.Context(), userToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong verifying the email address", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerEmailChangeConfirm applies an email change requested through
+// handlerUserPatch once the new address has proved it receives mail.
+func (cfg *apiConfig) handlerEmailChangeConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	// The token is only used up if the address actually changes, so a
+	// conflict leaves it valid for another try.
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong changing the email address", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := database.New(tracing.WrapDB(tx))
+
+	userToken, err := qtx.ConsumeUserToken(r.Context(), database.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposeEmailChange,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+
+	oldUser, err := qtx.GetUserByID(r.Context(), userToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	dbUser, err := qtx.ChangeEmail(r.Context(), database.ChangeEmailParams{
+		ID:    userToken.UserID,
+		Email: userToken.Payload,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email address is already in use", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong changing the email address", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong changing the email address", err)
+		return
+	}
+
+	err = cfg.mailer.Send(r.Context(), mailer.Message{
+		To:      oldUser.Email,
+		Subject: "Your Chirpy email address was changed",
+		Body: fmt.Sprintf("The email address on your Chirpy account was changed to %s.\n\n"+
+			"If this wasn't you, reset your password and contact support.", dbUser.Email),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sending email change notice", "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) sendEmailChange(ctx context.Context, userID uuid.UUID, newEmail string) error {
+	return cfg.sendUserToken(ctx, userID, newEmail, tokenPurposeEmailChange, newEmail, 24*time.Hour,
+		"Confirm your new Chirpy email address",
+		"Someone asked to use this address for their Chirpy account.\n\n"+
+			"Open this link to confirm the change:\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.",
+		"/app/confirm-email")
+}
+
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, dbUser database.User) error {
+	return cfg.sendUserToken(ctx, dbUser.ID, dbUser.Email, tokenPurposeEmailVerification, "", 24*time.Hour,
+		"Confirm your Chirpy email address",
+		"Welcome to Chirpy!\n\nPlease confirm your email address by opening this link:\n%s",
+		"/app/verify-email")
+}
+
+// sendUserToken replaces any outstanding token for the same purpose with a
+// fresh one and mails a link containing it. Only the hash is stored, along
+// with payload for tokens that carry data such as a pending email address.
+func (cfg *apiConfig) sendUserToken(ctx context.Context, userID uuid.UUID, email, purpose, payload string, ttl time.Duration, subject, bodyFmt, path string) error {
+	token, err := auth.MakeUserToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.DeleteUnusedUserTokens(ctx, database.DeleteUnusedUserTokensParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.db.CreateUserToken(ctx, database.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + path + "?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf(bodyFmt, link),
+	})
+}
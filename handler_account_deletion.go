@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/tracing"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const housekeepingInterval = time.Hour
+
+// handlerUserDelete schedules the caller's account for deletion. Nothing
+// is removed until the grace period ends, but every session and API key
+// is revoked straight away; logging in again and calling
+// handlerUserDeleteCancel keeps the account.
+//
+// Accounts with a password confirm with it. Accounts that only sign in
+// through an identity provider have none, so they get an emailed token to
+// pass to handlerUserDeleteConfirm instead.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	if dbUser.PasswordHash == externalPasswordHash {
+		err = cfg.sendUserToken(r.Context(), dbUser.ID, dbUser.Email, tokenPurposeAccountDeletion, "", time.Hour,
+			"Confirm deleting your Chirpy account",
+			"Someone asked to delete your Chirpy account.\n\n"+
+				"Use this link within the next hour to confirm:\n%s\n\n"+
+				"If this wasn't you, you can ignore this email.",
+			"/app/confirm-deletion")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong sending the confirmation email", err)
+			return
+		}
+		type response struct {
+			Confirmation string `json:"confirmation"`
+		}
+		respondWithJSON(w, http.StatusAccepted, response{Confirmation: "email"})
+		return
+	}
+
+	match, err := auth.CheckPasswordHash(params.Password, dbUser.PasswordHash)
+	if err != nil || !match {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	cfg.scheduleUserDeletion(w, r, userID)
+}
+
+// handlerUserDeleteConfirm finishes a deletion requested through
+// handlerUserDelete by an account without a password. The token must
+// belong to the authenticated user.
+func (cfg *apiConfig) handlerUserDeleteConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	pending, err := cfg.db.GetUserToken(r.Context(), database.GetUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposeAccountDeletion,
+	})
+	if err != nil || pending.UserID != userID {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+	_, err = cfg.db.ConsumeUserToken(r.Context(), database.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   tokenPurposeAccountDeletion,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token", err)
+		return
+	}
+
+	cfg.scheduleUserDeletion(w, r, userID)
+}
+
+func (cfg *apiConfig) scheduleUserDeletion(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	dbUser, err := cfg.db.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:                   userID,
+		DeletionScheduledFor: sql.NullTime{Time: time.Now().UTC().Add(cfg.deletionGrace), Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong scheduling the deletion", err)
+		return
+	}
+
+	err = cfg.db.RevokeAllSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the sessions", err)
+		return
+	}
+	err = cfg.db.RevokeAllAPIKeys(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the API keys", err)
+		return
+	}
+
+	type response struct {
+		DeletionScheduledFor time.Time `json:"deletion_scheduled_for"`
+	}
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeletionScheduledFor: dbUser.DeletionScheduledFor.Time,
+	})
+}
+
+func (cfg *apiConfig) handlerUserDeleteCancel(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cancelled, err := cfg.db.CancelUserDeletion(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong cancelling the deletion", err)
+		return
+	}
+	if cancelled == 0 {
+		respondWithError(w, http.StatusNotFound, "No deletion is scheduled for this account", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeDeletedUsers deletes accounts whose grace period has ended. Auth
+// events and login failure counters only name the user by email once
+// user_id is cleared, so they are deleted in the same transaction.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context) (int64, error) {
+	tx, err := cfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := database.New(tracing.WrapDB(tx))
+
+	if err := qtx.DeletePurgedUserAuthEvents(ctx); err != nil {
+		return 0, err
+	}
+	if err := qtx.DeletePurgedUserLoginFailures(ctx); err != nil {
+		return 0, err
+	}
+	purged, err := qtx.PurgeDeletedUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return purged, tx.Commit()
+}
+
+// runHousekeeping periodically deletes accounts whose grace period has
+// ended, drops expired data exports, old webhook delivery attempts and
+// login failure counters, and downgrades lapsed subscriptions.
+// Chirps, sessions and everything else owned by a purged user go with it
+// through ON DELETE CASCADE.
+func (cfg *apiConfig) runHousekeeping(ctx context.Context) {
+	ticker := time.NewTicker(housekeepingInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, purgeErr := cfg.purgeDeletedUsers(ctx)
+		if purgeErr != nil {
+			slog.ErrorContext(ctx, "purging deleted accounts", "error", purgeErr)
+		} else if purged > 0 {
+			slog.InfoContext(ctx, "purged deleted accounts", "count", purged)
+		}
+
+		exportErr := cfg.db.DeleteExpiredDataExports(ctx)
+		if exportErr != nil {
+			slog.ErrorContext(ctx, "deleting expired data exports", "error", exportErr)
+		}
+
+		attempts, attemptErr := cfg.db.DeleteOldWebhookDeliveryAttempts(ctx, time.Now().UTC().Add(-webhookAttemptRetention))
+		if attemptErr != nil {
+			slog.ErrorContext(ctx, "deleting old webhook delivery attempts", "error", attemptErr)
+		} else if attempts > 0 {
+			slog.InfoContext(ctx, "deleted old webhook delivery attempts", "count", attempts)
+		}
+
+		// A counter past its window would start again from one, and every
+		// lock is shorter than the window, so nothing is lost.
+		failures, failureErr := cfg.db.DeleteExpiredLoginFailures(ctx, time.Now().Add(-loginFailureRetention))
+		if failureErr != nil {
+			slog.ErrorContext(ctx, "deleting expired login failures", "error", failureErr)
+		} else if failures > 0 {
+			slog.InfoContext(ctx, "deleted expired login failures", "count", failures)
+		}
+
+		// One statement, so a user is never left Chirpy Red with an
+		// expired subscription.
+		expired, expireErr := cfg.db.ExpireSubscriptions(ctx)
+		if expireErr != nil {
+			slog.ErrorContext(ctx, "expiring subscriptions", "error", expireErr)
+		} else if expired > 0 {
+			slog.InfoContext(ctx, "expired subscriptions", "count", expired)
+		}
+		cfg.workers.beat(workerHousekeeping, errors.Join(purgeErr, exportErr, attemptErr, failureErr, expireErr))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
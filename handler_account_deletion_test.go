@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserDeleteWithoutPasswordConfirmsByEmail(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "kim@wexlermcgill.com", PasswordHash: externalPasswordHash}
+
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "DeleteUnusedUserTokens").WithArgs(user.ID, tokenPurposeAccountDeletion).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectQuery(mock, "CreateUserToken").WillReturnRows(modelRows(database.UserToken{ID: uuid.New(), UserID: user.ID}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	rec := httptest.NewRecorder()
+	cfg.handlerUserDelete(rec, req)
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"confirmation":"email"`) {
+		t.Fatalf("Expected an emailed confirmation, got %d %s", rec.Code, rec.Body)
+	}
+
+	msgs := cfg.mailer.(*mailer.MemoryMailer).Messages()
+	if len(msgs) != 1 || msgs[0].To != user.Email {
+		t.Fatalf("Expected one confirmation email, got %+v", msgs)
+	}
+	link, err := url.Parse(strings.Fields(msgs[0].Body[strings.Index(msgs[0].Body, "http"):])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	scheduled := user
+	scheduled.DeletionScheduledFor = sql.NullTime{Time: time.Now().Add(cfg.deletionGrace), Valid: true}
+	pending := database.UserToken{ID: uuid.New(), UserID: user.ID, Purpose: tokenPurposeAccountDeletion}
+	expectQuery(mock, "GetUserToken").WillReturnRows(modelRows(pending))
+	expectQuery(mock, "ConsumeUserToken").WillReturnRows(modelRows(pending))
+	expectQuery(mock, "ScheduleUserDeletion").WillReturnRows(modelRows(scheduled))
+	expectExec(mock, "RevokeAllSessions").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectExec(mock, "RevokeAllAPIKeys").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req = httptest.NewRequest(http.MethodPost, "/api/users/confirm-deletion", strings.NewReader(`{"token":"`+token+`"}`))
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	rec = httptest.NewRecorder()
+	cfg.handlerUserDeleteConfirm(rec, req)
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), "deletion_scheduled_for") {
+		t.Fatalf("Expected the deletion to be scheduled, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUserDeleteConfirmRejectsOtherUsersToken(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	expectQuery(mock, "GetUserToken").WillReturnRows(modelRows(database.UserToken{ID: uuid.New(), UserID: uuid.New()}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/confirm-deletion", strings.NewReader(`{"token":"abc"}`))
+	req.Header.Set("Authorization", bearer(t, uuid.New()))
+	rec := httptest.NewRecorder()
+	cfg.handlerUserDeleteConfirm(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected another user's token to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Auth events and lockout counters keyed by the email address must go
+// with the account, or the address outlives the purge.
+func TestPurgeDeletedUsersRemovesPersonalData(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	mock.ExpectBegin()
+	expectExec(mock, "DeletePurgedUserAuthEvents").WillReturnResult(sqlmock.NewResult(0, 3))
+	expectExec(mock, "DeletePurgedUserLoginFailures").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectExec(mock, "PurgeDeletedUsers").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	purged, err := cfg.purgeDeletedUsers(t.Context())
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected one purged account, got %d, %v", purged, err)
+	}
+}
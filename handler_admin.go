@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+)
+
+// handlerAdminBootstrap promotes the first admin. It is authenticated with
+// ADMIN_KEY and stops working once any admin exists; after that, admins
+// manage roles through handlerAdminSetRole.
+func (cfg *apiConfig) handlerAdminBootstrap(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	keyStr, err := auth.GetAPIKey(r.Header)
+	if err != nil || cfg.adminKey == "" || !auth.CheckTokenHash(keyStr, auth.HashToken(cfg.adminKey)) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized user", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	// The check and the promotion are one statement, so two concurrent
+	// bootstraps can't both find no admin and both succeed.
+	dbUser, err = cfg.db.PromoteFirstAdmin(r.Context(), dbUser.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "An admin already exists", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the role", err)
+		return
+	}
+	cfg.respondWithRoleChange(w, r, dbUser)
+}
+
+func (cfg *apiConfig) handlerAdminSetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+	if !validRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role", nil)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	if dbUser.Role == roleAdmin && params.Role != roleAdmin {
+		admins, err := cfg.db.CountUsersWithRole(r.Context(), roleAdmin)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong counting admins", err)
+			return
+		}
+		if admins <= 1 {
+			respondWithError(w, http.StatusConflict, "Cannot demote the last admin", nil)
+			return
+		}
+	}
+
+	cfg.setRole(w, r, userID, params.Role)
+}
+
+func (cfg *apiConfig) setRole(w http.ResponseWriter, r *http.Request, userID uuid.UUID, role string) {
+	dbUser, err := cfg.db.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the role", err)
+		return
+	}
+	cfg.respondWithRoleChange(w, r, dbUser)
+}
+
+func (cfg *apiConfig) respondWithRoleChange(w http.ResponseWriter, r *http.Request, dbUser database.User) {
+	cfg.recordAuthEvent(r, "role_changed", uuid.NullUUID{UUID: dbUser.ID, Valid: true}, "role="+dbUser.Role)
+
+	respondWithJSON(w, http.StatusOK, User{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt,
+		UpdatedAt:   dbUser.UpdatedAt,
+		Email:       dbUser.Email,
+		IsChirpyRed: dbUser.IsChirpyRed,
+		IsVerified:  dbUser.EmailVerifiedAt.Valid,
+		Role:        dbUser.Role,
+	})
+}
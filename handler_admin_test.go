@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A token minted while the user was an admin stops working for admin
+// routes as soon as the user is demoted.
+func TestAuthorizeReadsRoleFromDatabase(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", Role: roleUser}
+	token, err := auth.MakeJWT(user.ID, roleAdmin, testSecretKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+
+	called := false
+	handler := cfg.requirePermission(permMetricsRead, func(w http.ResponseWriter, r *http.Request) { called = true })
+	req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if called || rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected the demoted admin to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdminBootstrapRefusedOnceAdminExists(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	cfg.adminKey = "bootstrap-key"
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", Role: roleUser}
+	expectQuery(mock, "GetUser").WithArgs(user.Email).WillReturnRows(modelRows(user))
+	expectQuery(mock, "PromoteFirstAdmin").WithArgs(user.ID).WillReturnError(sql.ErrNoRows)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/bootstrap", strings.NewReader(`{"email":"saul@bettercall.com"}`))
+	req.Header.Set("Authorization", "ApiKey bootstrap-key")
+	rec := httptest.NewRecorder()
+	cfg.handlerAdminBootstrap(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected the bootstrap to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
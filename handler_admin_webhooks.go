@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultWebhookEventsLimit = 100
+
+type WebhookEvent struct {
+	ID            uuid.UUID       `json:"id"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	Provider      string          `json:"provider"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int32           `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	ProcessedAt   *time.Time      `json:"processed_at,omitempty"`
+}
+
+func webhookEventFromDB(e database.WebhookEvent) WebhookEvent {
+	event := WebhookEvent{
+		ID:            e.ID,
+		ReceivedAt:    e.ReceivedAt,
+		Provider:      e.Provider,
+		EventID:       e.EventID,
+		EventType:     e.EventType,
+		Payload:       e.Payload,
+		Status:        e.Status,
+		Attempts:      e.Attempts,
+		LastError:     e.LastError,
+		NextAttemptAt: e.NextAttemptAt,
+	}
+	if e.ProcessedAt.Valid {
+		event.ProcessedAt = &e.ProcessedAt.Time
+	}
+	return event
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEventsList(w http.ResponseWriter, r *http.Request) {
+	limit := int32(defaultWebhookEventsLimit)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 1 || n > defaultWebhookEventsLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		limit = int32(n)
+	}
+
+	var dbEvents []database.WebhookEvent
+	var err error
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		dbEvents, err = cfg.db.ListAllWebhookEvents(r.Context(), limit)
+	case webhookStatusPending, webhookStatusProcessed, webhookStatusFailed:
+		dbEvents, err = cfg.db.ListWebhookEvents(r.Context(), database.ListWebhookEventsParams{
+			Status: status,
+			Limit:  limit,
+		})
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook events", err)
+		return
+	}
+
+	events := []WebhookEvent{}
+	for _, e := range dbEvents {
+		events = append(events, webhookEventFromDB(e))
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func (cfg *apiConfig) handlerAdminWebhookEventGet(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid event ID", err)
+		return
+	}
+
+	event, err := cfg.db.GetWebhookEvent(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Webhook event not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
+
+// handlerAdminWebhookEventReplay resets an event to pending and processes it
+// again, including events that were already processed successfully.
+func (cfg *apiConfig) handlerAdminWebhookEventReplay(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid event ID", err)
+		return
+	}
+
+	_, err = cfg.db.ResetWebhookEvent(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Webhook event not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong resetting the webhook event", err)
+		return
+	}
+
+	if err := cfg.processWebhookEvent(r.Context(), id); err != nil {
+		slog.ErrorContext(r.Context(), "replaying webhook event", "event_id", id, "error", err)
+	}
+
+	event, err := cfg.db.GetWebhookEvent(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook event", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func apiKeyFromDB(k database.ApiKey) APIKey {
+	a := APIKey{
+		ID:        k.ID,
+		CreatedAt: k.CreatedAt,
+		Name:      k.Name,
+		Scopes:    strings.Fields(k.Scopes),
+	}
+	if k.LastUsedAt.Valid {
+		a.LastUsedAt = &k.LastUsedAt.Time
+	}
+	return a
+}
+
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Key name is required", nil)
+		return
+	}
+
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "Unsupported scope: "+scope, nil)
+			return
+		}
+	}
+
+	key, selector, secret, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the API key", err)
+		return
+	}
+
+	dbKey, err := cfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:   userID,
+		Name:     params.Name,
+		Selector: selector,
+		KeyHash:  auth.HashToken(secret),
+		Scopes:   strings.Join(params.Scopes, " "),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the API key", err)
+		return
+	}
+
+	// The plaintext key is only ever returned here.
+	k := apiKeyFromDB(dbKey)
+	k.Key = key
+	respondWithJSON(w, http.StatusCreated, k)
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbKeys, err := cfg.db.GetAPIKeysByUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the API keys", err)
+		return
+	}
+
+	keys := []APIKey{}
+	for _, k := range dbKeys {
+		keys = append(keys, apiKeyFromDB(k))
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("keyID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid key ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     id,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the API key", err)
+		return
+	}
+
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
-	"github.com/mr_rambling/chirpy/internal/auth"
 	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/tracing"
 	"net/http"
 	"slices"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
@@ -24,30 +27,61 @@ func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	id, err := cfg.authenticate(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authorization token missing", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	id, err := auth.ValidateJWT(token, cfg.secretKey)
+	_, limits, err := cfg.limitsFor(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid authorization token", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the entitlements", err)
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	if utf8.RuneCountInString(params.Body) > limits.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp is too long (max %d characters)", limits.MaxChirpLength), nil)
 		return
 	}
 
+	// The rate limit is checked and the chirp inserted while holding the
+	// user's row lock, so concurrent requests can't all pass the count.
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := database.New(tracing.WrapDB(tx))
+
+	if limits.ChirpsPerMinute > 0 {
+		err = qtx.LockUser(r.Context(), id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong checking the rate limit", err)
+			return
+		}
+		recent, err := qtx.CountChirpsByUserSince(r.Context(), database.CountChirpsByUserSinceParams{
+			UserID:    id,
+			CreatedAt: time.Now().Add(-time.Minute),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong checking the rate limit", err)
+			return
+		}
+		if recent >= int64(limits.ChirpsPerMinute) {
+			w.Header().Set("Retry-After", "60")
+			respondWithError(w, http.StatusTooManyRequests, "Too many chirps; try again in a minute", nil)
+			return
+		}
+	}
+
 	type returnVal struct {
 		Censored string `json:"cleaned_body"`
 	}
 
 	cleaned := returnVal{Censored: censorChirp(params.Body)}
 
-	dbChirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
+	dbChirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
 		Body:   cleaned.Censored,
 		UserID: id,
 	})
@@ -55,6 +89,10 @@ func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the chirp", err)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the chirp", err)
+		return
+	}
 
 	c := Chirp{
 		ID:        dbChirp.ID,
@@ -64,6 +102,8 @@ func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 		UserID:    dbChirp.UserID,
 	}
 
+	cfg.metrics.ChirpsCreated.Inc()
+	cfg.emitWebhookEvent(r.Context(), c.UserID, eventChirpCreated, c)
 	respondWithJSON(w, http.StatusCreated, c)
 }
 
@@ -125,6 +165,11 @@ func (cfg *apiConfig) handlerRetrieveChirps(w http.ResponseWriter, r *http.Reque
 		})
 	}
 
+	if err := cfg.embedAuthors(r, chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the authors", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, chirps)
 }
 
@@ -155,7 +200,12 @@ func (cfg *apiConfig) handlerRetrieveChirp(w http.ResponseWriter, r *http.Reques
 		UserID:    dbChirp.UserID,
 	}
 
-	respondWithJSON(w, http.StatusOK, c)
+	chirps := []Chirp{c}
+	if err := cfg.embedAuthors(r, chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the author", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
 }
 
 func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request) {
@@ -166,15 +216,9 @@ func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authorization token missing", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.secretKey)
+	userID, err := cfg.authenticate(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid authorization token", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
@@ -190,8 +234,10 @@ func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request)
 	}
 
 	if dbChirp.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You do not have permission to delete this chirp", nil)
-		return
+		if _, err := cfg.authorize(r, permChirpsModerate); err != nil {
+			respondWithError(w, http.StatusForbidden, "You do not have permission to delete this chirp", err)
+			return
+		}
 	}
 
 	err = cfg.db.DeleteChirp(r.Context(), id)
@@ -200,5 +246,14 @@ func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	type deletedChirp struct {
+		ID     uuid.UUID `json:"id"`
+		UserID uuid.UUID `json:"user_id"`
+	}
+	cfg.emitWebhookEvent(r.Context(), dbChirp.UserID, eventChirpDeleted, deletedChirp{
+		ID:     dbChirp.ID,
+		UserID: dbChirp.UserID,
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
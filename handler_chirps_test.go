@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postChirp(t *testing.T, cfg *apiConfig, userID uuid.UUID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"`+body+`"}`))
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	cfg.handlerChirps(rec, req)
+	return rec
+}
+
+// The length limit is in characters, so a chirp of 140 multi-byte
+// characters still fits on the free tier.
+func TestChirpLengthCountsCharacters(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	body := strings.Repeat("é", 140)
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	mock.ExpectBegin()
+	expectExec(mock, "LockUser").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectQuery(mock, "CountChirpsByUserSince").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectQuery(mock, "CreateChirp").WithArgs(body, user.ID).
+		WillReturnRows(modelRows(database.Chirp{ID: uuid.New(), Body: body, UserID: user.ID}))
+	mock.ExpectCommit()
+	expectQuery(mock, "GetEnabledWebhookEndpointsByUser").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows(nil))
+
+	if rec := postChirp(t, cfg, user.ID, body); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the chirp to be created, got %d %s", rec.Code, rec.Body)
+	}
+
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	if rec := postChirp(t, cfg, user.ID, body+"é"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 141 characters to be too long, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChirpRateLimitRollsBack(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	mock.ExpectBegin()
+	expectExec(mock, "LockUser").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectQuery(mock, "CountChirpsByUserSince").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(cfg.tiers.For("free").ChirpsPerMinute))
+	mock.ExpectRollback()
+
+	rec := postChirp(t, cfg, user.ID, "hello")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected the rate limit to apply, got %d %s", rec.Code, rec.Body)
+	}
+}
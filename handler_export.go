@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+const (
+	exportStatusPending = "pending"
+	exportStatusReady   = "ready"
+	exportStatusFailed  = "failed"
+
+	exportTTL          = 24 * time.Hour
+	exportBuildTimeout = 5 * time.Minute
+)
+
+// handlerUserExport starts a data export on the first call and answers 202
+// while the archive is built in the background. Once it's ready, the same
+// request downloads the ZIP until the export expires.
+func (cfg *apiConfig) handlerUserExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	export, err := cfg.db.GetLatestDataExport(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the export", err)
+		return
+	}
+
+	// A pending export that outlived its build timeout was lost, e.g. to a
+	// crash, and is started again.
+	lost := export.Status == exportStatusPending && time.Since(export.CreatedAt) > exportBuildTimeout
+	if errors.Is(err, sql.ErrNoRows) || export.Status == exportStatusFailed || lost {
+		export, err = cfg.db.CreateDataExport(r.Context(), database.CreateDataExportParams{
+			UserID:    userID,
+			ExpiresAt: time.Now().UTC().Add(exportTTL),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong starting the export", err)
+			return
+		}
+		exportID := export.ID
+		cfg.runInBackground(r.Context(), "building data export", func(ctx context.Context) error {
+			return cfg.buildDataExport(ctx, exportID, userID)
+		})
+	}
+
+	if export.Status != exportStatusReady {
+		type response struct {
+			ID     uuid.UUID `json:"id"`
+			Status string    `json:"status"`
+		}
+		w.Header().Set("Retry-After", "10")
+		respondWithJSON(w, http.StatusAccepted, response{ID: export.ID, Status: export.Status})
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%s.zip", export.CompletedAt.Time.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// buildDataExport marks the export failed when it can't finish, including
+// when shutdown cancels ctx, so the next request starts it again.
+func (cfg *apiConfig) buildDataExport(ctx context.Context, exportID, userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, exportBuildTimeout)
+	defer cancel()
+
+	archive, err := cfg.exportArchive(ctx, userID)
+	if err != nil {
+		if failErr := cfg.db.FailDataExport(context.WithoutCancel(ctx), exportID); failErr != nil {
+			return errors.Join(err, fmt.Errorf("marking data export %s as failed: %w", exportID, failErr))
+		}
+		return fmt.Errorf("data export %s: %w", exportID, err)
+	}
+
+	err = cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:      exportID,
+		Archive: archive,
+	})
+	if err != nil {
+		return fmt.Errorf("saving data export %s: %w", exportID, err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) exportArchive(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	dbUser, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	type exportProfile struct {
+		User
+		Handle      string   `json:"handle"`
+		DisplayName string   `json:"display_name"`
+		Bio         string   `json:"bio"`
+		AvatarURL   string   `json:"avatar_url"`
+		Following   []Author `json:"following"`
+		Followers   []Author `json:"followers"`
+	}
+	profile := exportProfile{
+		User: User{
+			ID:          dbUser.ID,
+			CreatedAt:   dbUser.CreatedAt,
+			UpdatedAt:   dbUser.UpdatedAt,
+			Email:       dbUser.Email,
+			IsChirpyRed: dbUser.IsChirpyRed,
+			IsVerified:  dbUser.EmailVerifiedAt.Valid,
+			Role:        dbUser.Role,
+		},
+		Handle:      dbUser.Handle.String,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarURL:   dbUser.AvatarUrl,
+		Following:   []Author{},
+		Followers:   []Author{},
+	}
+
+	following, err := cfg.db.GetFollowing(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range following {
+		profile.Following = append(profile.Following, Author{ID: f.ID, Handle: f.Handle.String, DisplayName: f.DisplayName, AvatarURL: f.AvatarUrl})
+	}
+	followers, err := cfg.db.GetFollowers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range followers {
+		profile.Followers = append(profile.Followers, Author{ID: f.ID, Handle: f.Handle.String, DisplayName: f.DisplayName, AvatarURL: f.AvatarUrl})
+	}
+
+	dbChirps, err := cfg.db.GetChirpsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	chirps := []Chirp{}
+	for _, c := range dbChirps {
+		chirps = append(chirps, Chirp{
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body:      c.Body,
+			UserID:    c.UserID,
+		})
+	}
+
+	dbSessions, err := cfg.db.GetSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, s := range dbSessions {
+		sessions = append(sessions, sessionFromDB(s))
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"chirps.json", chirps},
+		{"sessions.json", sessions},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"testing"
+)
+
+func TestBuildDataExportMarksFailure(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	exportID, userID := uuid.New(), uuid.New()
+
+	expectQuery(mock, "GetUserByID").WithArgs(userID).WillReturnError(errors.New("connection reset"))
+	expectExec(mock, "FailDataExport").WithArgs(exportID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := cfg.buildDataExport(context.Background(), exportID, userID); err == nil {
+		t.Fatalf("Expected the export to fail")
+	}
+}
+
+func TestExportArchiveIncludesProfile(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{
+		ID:          uuid.New(),
+		Email:       "saul@bettercall.com",
+		Handle:      sql.NullString{String: "saul", Valid: true},
+		DisplayName: "Saul Goodman",
+		Bio:         "Better call",
+		AvatarUrl:   "https://example.com/saul.png",
+	}
+	kim := database.GetFollowingRow{ID: uuid.New(), Handle: sql.NullString{String: "kim", Valid: true}, DisplayName: "Kim Wexler"}
+	mike := database.GetFollowersRow{ID: uuid.New(), Handle: sql.NullString{String: "mike", Valid: true}}
+
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "GetFollowing").WithArgs(user.ID).WillReturnRows(modelRows(kim))
+	expectQuery(mock, "GetFollowers").WithArgs(user.ID).WillReturnRows(modelRows(mike))
+	expectQuery(mock, "GetChirpsByUser").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows(nil))
+	expectQuery(mock, "GetSessions").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows(nil))
+
+	archive, err := cfg.exportArchive(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("profile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var profile struct {
+		Email       string   `json:"email"`
+		Handle      string   `json:"handle"`
+		DisplayName string   `json:"display_name"`
+		Bio         string   `json:"bio"`
+		AvatarURL   string   `json:"avatar_url"`
+		Following   []Author `json:"following"`
+		Followers   []Author `json:"followers"`
+	}
+	if err := json.NewDecoder(f).Decode(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Email != user.Email || profile.Handle != "saul" || profile.DisplayName != user.DisplayName ||
+		profile.Bio != user.Bio || profile.AvatarURL != user.AvatarUrl {
+		t.Fatalf("Profile fields missing from the export: %+v", profile)
+	}
+	if len(profile.Following) != 1 || profile.Following[0].Handle != "kim" ||
+		len(profile.Followers) != 1 || profile.Followers[0].Handle != "mike" {
+		t.Fatalf("Follow lists missing from the export: %+v", profile)
+	}
+}
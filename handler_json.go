@@ -2,21 +2,22 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 func respondWithError(w http.ResponseWriter, statusCode int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
+	requestID := w.Header().Get(requestIDHeader)
 	if statusCode > 499 {
-		log.Printf("Internal server 5XX error: %s", msg)
+		slog.Error(msg, "request_id", requestID, "status", statusCode, "error", err)
+	} else if err != nil {
+		slog.Info(msg, "request_id", requestID, "status", statusCode, "error", err)
 	}
 	type errorResp struct {
-		Error string `json:"error"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
 	}
-	respondWithJSON(w, statusCode, errorResp{Error: msg})
+	respondWithJSON(w, statusCode, errorResp{Error: msg, RequestID: requestID})
 }
 
 func respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
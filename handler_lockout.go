@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/lockout"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Failure counters are dropped by housekeeping once they are older than
+// the longest lockout window.
+var loginFailureRetention = max(lockout.AccountPolicy.Window, lockout.IPPolicy.Window)
+
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginAllowed responds with 429 and returns false while either the account
+// or the client IP is backing off.
+func (cfg *apiConfig) loginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	checks := []struct {
+		limiter *lockout.Limiter
+		key     string
+	}{
+		{cfg.accountLimiter, accountLockoutKey(email)},
+		{cfg.ipLimiter, ipLockoutKey(clientIP(r))},
+	}
+
+	for _, c := range checks {
+		wait, err := c.limiter.Check(r.Context(), c.key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong checking login attempts", err)
+			return false
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+			return false
+		}
+	}
+	return true
+}
+
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string, userID uuid.NullUUID) {
+	cfg.metrics.FailedLogins.Inc()
+	ip := clientIP(r)
+
+	res, err := cfg.accountLimiter.Fail(r.Context(), accountLockoutKey(email))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording login failure", "error", err)
+	} else if res.LockedOut {
+		cfg.recordAuthEvent(r, "account_locked", userID, accountLockoutKey(email))
+	}
+
+	res, err = cfg.ipLimiter.Fail(r.Context(), ipLockoutKey(ip))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording login failure", "error", err)
+	} else if res.LockedOut {
+		cfg.recordAuthEvent(r, "ip_locked", uuid.NullUUID{}, ipLockoutKey(ip))
+	}
+}
+
+func (cfg *apiConfig) recordAuthEvent(r *http.Request, event string, userID uuid.NullUUID, detail string) {
+	err := cfg.db.CreateAuthEvent(r.Context(), database.CreateAuthEventParams{
+		Event:  event,
+		UserID: userID,
+		Ip:     clientIP(r),
+		Detail: detail,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording auth event", "event", event, "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	if params.Email == "" && params.IP == "" {
+		respondWithError(w, http.StatusBadRequest, "An email or IP address is required", nil)
+		return
+	}
+
+	if params.Email != "" {
+		err = cfg.accountLimiter.Reset(r.Context(), accountLockoutKey(params.Email))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong unlocking the account", err)
+			return
+		}
+
+		userID := uuid.NullUUID{}
+		if dbUser, err := cfg.db.GetUser(r.Context(), params.Email); err == nil {
+			userID = uuid.NullUUID{UUID: dbUser.ID, Valid: true}
+		}
+		cfg.recordAuthEvent(r, "account_unlocked", userID, accountLockoutKey(params.Email))
+	}
+
+	if params.IP != "" {
+		err = cfg.ipLimiter.Reset(r.Context(), ipLockoutKey(params.IP))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong unlocking the IP address", err)
+			return
+		}
+		cfg.recordAuthEvent(r, "ip_unlocked", uuid.NullUUID{}, ipLockoutKey(params.IP))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/mr_rambling/chirpy/internal/auth"
 	"net/http"
 )
 
@@ -13,14 +14,49 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 		<body>
 			<h1>Welcome, Chirpy Admin</h1>
 			<p>Chirpy has been visited %d times!</p>
+			<ul>
+				<li>Chirps created: %d</li>
+				<li>Logins: %d</li>
+				<li>Failed logins: %d</li>
+			</ul>
 		</body>
 	</html>
-	`, cfg.fileserverHits.Load())))
+	`,
+		cfg.fileserverHits(),
+		int64(cfg.metrics.CounterValue("chirpy_chirps_created_total")),
+		int64(cfg.metrics.CounterValue("chirpy_logins_total")),
+		int64(cfg.metrics.CounterValue("chirpy_failed_logins_total")),
+	)))
+}
+
+// fileserverHits reports hits since the last /admin/reset. Prometheus
+// counters never go down, so a reset only moves the baseline.
+func (cfg *apiConfig) fileserverHits() int64 {
+	return int64(cfg.metrics.CounterValue("chirpy_fileserver_hits_total")) - cfg.hitsBaseline.Load()
+}
+
+func (cfg *apiConfig) resetFileserverHits() {
+	cfg.hitsBaseline.Store(int64(cfg.metrics.CounterValue("chirpy_fileserver_hits_total")))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
+		cfg.metrics.FileserverHits.Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireMetricsToken guards the Prometheus endpoint with METRICS_TOKEN when
+// it is set, so scrapers can authenticate without a user account.
+func (cfg *apiConfig) requireMetricsToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.metricsToken != "" {
+			token, err := auth.GetBearerToken(r.Header)
+			if err != nil || !auth.CheckTokenHash(token, auth.HashToken(cfg.metricsToken)) {
+				respondWithError(w, http.StatusUnauthorized, "Invalid metrics token", nil)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	oauthCodeTTL        = 10 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+	oauthRefreshTTL     = 60 * 24 * time.Hour
+)
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func oauthClientFromDB(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		CreatedAt:    c.CreatedAt,
+		Name:         c.Name,
+		RedirectURIs: strings.Fields(c.RedirectUris),
+		Scopes:       strings.Fields(c.Scopes),
+		Public:       c.IsPublic,
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Client name is required", nil)
+		return
+	}
+
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required", nil)
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+uri, err)
+			return
+		}
+	}
+
+	if len(params.Scopes) == 0 {
+		params.Scopes = defaultOAuthScopes
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(oauthScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "Unsupported scope: "+scope, nil)
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := ""
+	if !params.Public {
+		secret, err = auth.MakeUserToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the client secret", err)
+			return
+		}
+		secretHash = auth.HashToken(secret)
+	}
+
+	dbClient, err := cfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(params.RedirectURIs, " "),
+		Scopes:       strings.Join(params.Scopes, " "),
+		IsPublic:     params.Public,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the client", err)
+		return
+	}
+
+	c := oauthClientFromDB(dbClient)
+	c.Secret = secret
+	respondWithJSON(w, http.StatusCreated, c)
+}
+
+func (cfg *apiConfig) handlerOAuthClientsList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbClients, err := cfg.db.GetOAuthClientsByOwner(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the clients", err)
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, c := range dbClients {
+		clients = append(clients, oauthClientFromDB(c))
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+func (cfg *apiConfig) handlerOAuthClientDelete(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("clientID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      id,
+		OwnerID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong deleting the client", err)
+		return
+	}
+
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Client not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Fragment != "" || u.Host == "" {
+		return errors.New("redirect URI must be absolute and have no fragment")
+	}
+	host := u.Hostname()
+	if u.Scheme == "https" || (u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1")) {
+		return nil
+	}
+	return errors.New("redirect URI must use https")
+}
+
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+}
+
+// authorizeError is returned to the client's redirect URI when it is set,
+// and shown to the user otherwise, as RFC 6749 section 4.1.2.1 requires.
+type authorizeError struct {
+	Code        string
+	Description string
+	RedirectURI string
+	State       string
+}
+
+func (e *authorizeError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func (cfg *apiConfig) parseAuthorizeRequest(r *http.Request, v url.Values) (authorizeRequest, *authorizeError) {
+	clientID, err := uuid.Parse(v.Get("client_id"))
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_request", Description: "Invalid client_id"}
+	}
+
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_client", Description: "Unknown client"}
+	}
+
+	registered := strings.Fields(client.RedirectUris)
+	redirectURI := v.Get("redirect_uri")
+	if redirectURI == "" && len(registered) == 1 {
+		redirectURI = registered[0]
+	}
+	if !slices.Contains(registered, redirectURI) {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_request", Description: "Redirect URI is not registered for this client"}
+	}
+
+	req := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         v.Get("state"),
+		CodeChallenge: v.Get("code_challenge"),
+	}
+	fail := func(code, desc string) (authorizeRequest, *authorizeError) {
+		return authorizeRequest{}, &authorizeError{Code: code, Description: desc, RedirectURI: redirectURI, State: req.State}
+	}
+
+	if v.Get("response_type") != "code" {
+		return fail("unsupported_response_type", "Only the code response type is supported")
+	}
+
+	if req.CodeChallenge == "" || v.Get("code_challenge_method") != "S256" {
+		return fail("invalid_request", "PKCE with the S256 method is required")
+	}
+
+	allowed := strings.Fields(client.Scopes)
+	requested := strings.Fields(v.Get("scope"))
+	if len(requested) == 0 {
+		requested = allowed
+	}
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return fail("invalid_scope", "Scope "+scope+" is not allowed for this client")
+		}
+	}
+	req.Scope = strings.Join(requested, " ")
+
+	return req, nil
+}
+
+func redirectWithAuthorizeError(w http.ResponseWriter, r *http.Request, e *authorizeError) {
+	if e.RedirectURI == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(e.Description + "\n"))
+		return
+	}
+
+	q := url.Values{}
+	q.Set("error", e.Code)
+	q.Set("error_description", e.Description)
+	if e.State != "" {
+		q.Set("state", e.State)
+	}
+	http.Redirect(w, r, appendQuery(e.RedirectURI, q), http.StatusFound)
+}
+
+func appendQuery(rawURL string, q url.Values) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + q.Encode()
+	}
+	return rawURL + "?" + q.Encode()
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+	<body>
+		<h1>Authorize {{.Client.Name}}</h1>
+		<p>{{.Client.Name}} would like to access your Chirpy account with these permissions:</p>
+		<ul>
+			{{range .Scopes}}<li>{{.}}</li>{{end}}
+		</ul>
+		{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+		<form method="POST" action="/oauth/authorize">
+			<input type="hidden" name="response_type" value="code">
+			<input type="hidden" name="client_id" value="{{.Client.ID}}">
+			<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+			<input type="hidden" name="scope" value="{{.Scope}}">
+			<input type="hidden" name="state" value="{{.State}}">
+			<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+			<input type="hidden" name="code_challenge_method" value="S256">
+			<p><label>Email <input type="email" name="email" required></label></p>
+			<p><label>Password <input type="password" name="password" required></label></p>
+			<p><label>Two-factor code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+			<button type="submit" name="decision" value="allow">Allow</button>
+			<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+		</form>
+	</body>
+</html>
+`))
+
+func renderConsent(w http.ResponseWriter, status int, req authorizeRequest, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	consentTemplate.Execute(w, struct {
+		authorizeRequest
+		Scopes []string
+		Error  string
+	}{req, strings.Fields(req.Scope), errMsg})
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, authErr := cfg.parseAuthorizeRequest(r, r.URL.Query())
+	if authErr != nil {
+		redirectWithAuthorizeError(w, r, authErr)
+		return
+	}
+
+	renderConsent(w, http.StatusOK, req, "")
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong parsing the form", err)
+		return
+	}
+
+	req, authErr := cfg.parseAuthorizeRequest(r, r.PostForm)
+	if authErr != nil {
+		redirectWithAuthorizeError(w, r, authErr)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		redirectWithAuthorizeError(w, r, &authorizeError{
+			Code:        "access_denied",
+			Description: "The user denied the request",
+			RedirectURI: req.RedirectURI,
+			State:       req.State,
+		})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	if !cfg.loginAllowed(w, r, email) {
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(r.Context(), email)
+	if err != nil {
+		cfg.recordLoginFailure(r, email, uuid.NullUUID{})
+		renderConsent(w, http.StatusUnauthorized, req, "Incorrect email or password")
+		return
+	}
+
+	match, err := auth.CheckPasswordHash(r.PostForm.Get("password"), dbUser.PasswordHash)
+	if err != nil || !match {
+		cfg.recordLoginFailure(r, email, uuid.NullUUID{UUID: dbUser.ID, Valid: true})
+		renderConsent(w, http.StatusUnauthorized, req, "Incorrect email or password")
+		return
+	}
+	cfg.upgradePasswordHash(r.Context(), dbUser, r.PostForm.Get("password"))
+
+	if dbUser.TotpEnabled && !cfg.checkTOTP(r.Context(), dbUser, r.PostForm.Get("code")) {
+		cfg.recordLoginFailure(r, email, uuid.NullUUID{UUID: dbUser.ID, Valid: true})
+		renderConsent(w, http.StatusUnauthorized, req, "Invalid two-factor code")
+		return
+	}
+
+	code, err := auth.MakeUserToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the authorization code", err)
+		return
+	}
+
+	err = cfg.db.CreateOAuthCode(r.Context(), database.CreateOAuthCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        dbUser.ID,
+		RedirectUri:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the authorization code", err)
+		return
+	}
+
+	q := url.Values{}
+	q.Set("code", code)
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, q), http.StatusFound)
+}
+
+func respondWithOAuthError(w http.ResponseWriter, statusCode int, code, description string) {
+	type errorResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithJSON(w, statusCode, errorResp{Error: code, ErrorDescription: description})
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.db.ConsumeOAuthCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+		if err != nil || code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+			return
+		}
+		if !auth.CheckPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier")
+			return
+		}
+		cfg.respondWithOAuthTokens(w, r, client, code.UserID, code.Scope)
+
+	case "refresh_token":
+		selector, verifier, err := auth.SplitRefreshToken(r.PostForm.Get("refresh_token"))
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+
+		refToken, err := cfg.db.GetRefreshToken(r.Context(), selector)
+		if err != nil || !auth.CheckTokenHash(verifier, refToken.TokenHash) ||
+			refToken.ExpiresAt.Before(time.Now()) || refToken.RevokedAt.Valid ||
+			!refToken.ClientID.Valid || refToken.ClientID.UUID != client.ID {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+
+		scope := refToken.Scope
+		if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+			granted := strings.Fields(refToken.Scope)
+			for _, s := range requested {
+				if !slices.Contains(granted, s) {
+					respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "Scope "+s+" was not granted")
+					return
+				}
+			}
+			scope = strings.Join(requested, " ")
+		}
+
+		// Refresh tokens are rotated on every use. Only the request that
+		// actually revokes the token gets new ones, so two concurrent
+		// refreshes with the same token can't both succeed.
+		revoked, err := cfg.db.RevokeRefreshToken(r.Context(), database.RevokeRefreshTokenParams{
+			Selector:  selector,
+			TokenHash: refToken.TokenHash,
+		})
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong rotating the refresh token")
+			return
+		}
+		if revoked == 0 {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		cfg.respondWithOAuthTokens(w, r, client, refToken.UserID, scope)
+
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+	}
+}
+
+// authenticateOAuthClient accepts HTTP Basic credentials or client_id and
+// client_secret form fields. Public clients only identify themselves.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, bool) {
+	clientIDStr, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientIDStr, _ = url.QueryUnescape(clientIDStr)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientIDStr = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(clientIDStr)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+
+	if !client.IsPublic && !auth.CheckTokenHash(secret, client.SecretHash) {
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+func (cfg *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, r *http.Request, client database.OauthClient, userID uuid.UUID, scope string) {
+	refToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong creating the refresh token")
+		return
+	}
+
+	selector, verifier, err := auth.SplitRefreshToken(refToken)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong creating the refresh token")
+		return
+	}
+
+	session, err := cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		Selector:  selector,
+		TokenHash: auth.HashToken(verifier),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(oauthRefreshTTL),
+		UserAgent: r.UserAgent(),
+		Ip:        clientIP(r),
+		ClientID:  uuid.NullUUID{UUID: client.ID, Valid: true},
+		Scope:     scope,
+	})
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong saving the refresh token")
+		return
+	}
+
+	accessToken, err := auth.MakeOAuthJWT(userID, client.ID, session.ID, scope, cfg.secretKey, oauthAccessTokenTTL)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong creating the access token")
+		return
+	}
+
+	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refToken,
+		Scope:        scope,
+	})
+}
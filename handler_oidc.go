@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/oidc"
+	"net/http"
+	"time"
+)
+
+const (
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	// Matches the column default; argon2id can never match it, so users
+	// created through an identity provider can't log in with a password
+	// until they set one.
+	externalPasswordHash = "unset"
+)
+
+var errUnverifiedAccount = errors.New("an account with this email address exists but the address was never verified")
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	authURL, ok := cfg.startOIDCFlow(w, r, provider, "")
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCLink starts the same flow for a signed-in user, whose account
+// the identity is linked to on the callback. It answers with the URL to
+// send the browser to, since a redirect can't carry the access token.
+func (cfg *apiConfig) handlerOIDCLink(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeFirstParty)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	authURL, ok := cfg.startOIDCFlow(w, r, provider, userID.String())
+	if !ok {
+		return
+	}
+
+	type response struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	respondWithJSON(w, http.StatusOK, response{AuthorizationURL: authURL})
+}
+
+// startOIDCFlow stores the login state in a cookie and returns the
+// provider's authorization URL. It responds itself and returns false when
+// the flow can't start.
+func (cfg *apiConfig) startOIDCFlow(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, linkUserID string) (string, bool) {
+	state, err := oidc.NewLoginState(provider.Name(), oidcStateTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong starting the login", err)
+		return "", false
+	}
+	state.LinkUserID = linkUserID
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state.State, state.Nonce, state.CodeChallenge())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable", err)
+		return "", false
+	}
+
+	sealed, err := oidc.SealState(state, cfg.secretKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong starting the login", err)
+		return "", false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    sealed,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, true
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider returned an error: "+errCode, nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login state missing", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	state, err := oidc.OpenState(cookie.Value, cfg.secretKey, time.Now())
+	if err != nil || state.Provider != provider.Name() || state.State != query.Get("state") {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state", err)
+		return
+	}
+
+	tokens, err := provider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Something went wrong exchanging the authorization code", err)
+		return
+	}
+
+	idToken, err := provider.VerifyIDToken(r.Context(), tokens.IDToken, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid ID token", err)
+		return
+	}
+
+	if state.LinkUserID != "" {
+		cfg.linkIdentity(w, r, provider.Name(), idToken, state.LinkUserID)
+		return
+	}
+
+	dbUser, err := cfg.userForIdentity(r.Context(), provider.Name(), idToken)
+	if errors.Is(err, errUnverifiedAccount) {
+		respondWithError(w, http.StatusConflict, "An account with this email address already exists. Log in with its password and link "+
+			provider.Name()+" from your account instead.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Something went wrong linking the external identity", err)
+		return
+	}
+
+	cfg.completeLogin(w, r, dbUser)
+}
+
+// linkIdentity attaches the identity to the signed-in user who started
+// the flow. Both sides have been proven, so the addresses need not match.
+func (cfg *apiConfig) linkIdentity(w http.ResponseWriter, r *http.Request, provider string, idToken *oidc.IDToken, linkUserID string) {
+	userID, err := uuid.Parse(linkUserID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state", err)
+		return
+	}
+
+	identity, err := cfg.db.GetUserIdentity(r.Context(), database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil && identity.UserID != userID {
+		respondWithError(w, http.StatusConflict, "This identity is already linked to another account", nil)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.db.CreateUserIdentity(r.Context(), database.CreateUserIdentityParams{
+			UserID:   userID,
+			Provider: provider,
+			Subject:  idToken.Subject,
+			Email:    idToken.Email,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong linking the external identity", err)
+		return
+	}
+
+	type response struct {
+		Provider string `json:"provider"`
+		Email    string `json:"email"`
+	}
+	respondWithJSON(w, http.StatusOK, response{Provider: provider, Email: idToken.Email})
+}
+
+// userForIdentity returns the user linked to the external identity. An
+// unlinked identity is attached to the user with the same email address,
+// or to a new user, but only when the provider has verified that address.
+// An existing account must have verified it too: anyone can sign up with
+// an address they don't own, and linking would let the IdP user into an
+// account whose password someone else still knows.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, provider string, idToken *oidc.IDToken) (database.User, error) {
+	identity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil {
+		return cfg.db.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errors.New("identity provider did not return a verified email address")
+	}
+	if _, err := normalizeEmail(idToken.Email); err != nil {
+		return database.User{}, errors.New("identity provider returned an invalid email address")
+	}
+
+	dbUser, err := cfg.db.GetUser(ctx, idToken.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		dbUser, err = cfg.db.CreateUser(ctx, database.CreateUserParams{
+			Email:        idToken.Email,
+			PasswordHash: externalPasswordHash,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		if err := cfg.db.SetEmailVerified(ctx, dbUser.ID); err != nil {
+			return database.User{}, err
+		}
+	} else if err != nil {
+		return database.User{}, err
+	} else if !dbUser.EmailVerifiedAt.Valid {
+		return database.User{}, errUnverifiedAccount
+	}
+
+	_, err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID:   dbUser.ID,
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return cfg.db.GetUserByID(ctx, dbUser.ID)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/oidc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeIdP is a minimal OpenID Connect provider whose token endpoint
+// answers any code with an id_token for subject "idp-123".
+type fakeIdP struct {
+	srv   *httptest.Server
+	key   *rsa.PrivateKey
+	nonce string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.Discovery{
+			Issuer:                idp.srv.URL,
+			AuthorizationEndpoint: idp.srv.URL + "/authorize",
+			TokenEndpoint:         idp.srv.URL + "/token",
+			JWKSURI:               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            idp.srv.URL,
+			"sub":            "idp-123",
+			"aud":            "chirpy",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          idp.nonce,
+			"email":          "saul@bettercall.com",
+			"email_verified": true,
+		})
+		tkn.Header["kid"] = "test-key"
+		signed, err := tkn.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(oidc.TokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: signed})
+	})
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+// oidcCallback runs the callback for the state cookie that start set.
+func oidcCallback(t *testing.T, cfg *apiConfig, idp *fakeIdP, start *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	t.Helper()
+	cookies := start.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected the login state cookie, got %d cookies: %d %s", len(cookies), start.Code, start.Body)
+	}
+	state, err := oidc.OpenState(cookies[0].Value, cfg.secretKey, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.nonce = state.Nonce
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?code=good-code&state="+url.QueryEscape(state.State), nil)
+	req.SetPathValue("provider", "mock")
+	req.AddCookie(cookies[0])
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(rec, req)
+	return rec
+}
+
+func newOIDCTestConfig(t *testing.T) (*apiConfig, sqlmock.Sqlmock, *fakeIdP) {
+	t.Helper()
+	cfg, mock := newTestConfig(t)
+	idp := newFakeIdP(t)
+	cfg.oidcProviders = map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(oidc.Config{
+			Name:        "mock",
+			Issuer:      idp.srv.URL,
+			ClientID:    "chirpy",
+			RedirectURL: "http://localhost:8080/api/auth/oidc/mock/callback",
+		}),
+	}
+	return cfg, mock, idp
+}
+
+// Someone who signed up with another person's address must not have the
+// real owner's IdP login attached to the account they control.
+func TestOIDCRefusesToLinkUnverifiedAccount(t *testing.T) {
+	cfg, mock, idp := newOIDCTestConfig(t)
+	squatter := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	expectQuery(mock, "GetUserIdentity").WithArgs("mock", "idp-123").WillReturnError(sql.ErrNoRows)
+	expectQuery(mock, "GetUser").WithArgs(squatter.Email).WillReturnRows(modelRows(squatter))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil)
+	req.SetPathValue("provider", "mock")
+	start := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(start, req)
+
+	if rec := oidcCallback(t, cfg, idp, start); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected the unverified account to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCLinkFromSignedInAccount(t *testing.T) {
+	cfg, mock, idp := newOIDCTestConfig(t)
+	userID := uuid.New()
+	expectQuery(mock, "GetUserIdentity").WithArgs("mock", "idp-123").WillReturnError(sql.ErrNoRows)
+	expectQuery(mock, "CreateUserIdentity").WithArgs(userID, "mock", "idp-123", "saul@bettercall.com").
+		WillReturnRows(modelRows(database.UserIdentity{ID: uuid.New(), UserID: userID, Provider: "mock", Subject: "idp-123"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/oidc/mock/link", nil)
+	req.SetPathValue("provider", "mock")
+	req.Header.Set("Authorization", bearer(t, userID))
+	start := httptest.NewRecorder()
+	cfg.handlerOIDCLink(start, req)
+	if start.Code != http.StatusOK {
+		t.Fatalf("Expected the link flow to start, got %d %s", start.Code, start.Body)
+	}
+
+	if rec := oidcCallback(t, cfg, idp, start); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the identity to be linked, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// Handles share the /api/users/ path with fixed routes, so those names
+// can't be claimed.
+var reservedHandles = []string{"admin", "export", "me", "profile"}
+
+type Profile struct {
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	CreatedAt      time.Time `json:"created_at"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+	ChirpCount     int64     `json:"chirp_count"`
+}
+
+// Author is the compact user object embedded in chirps.
+type Author struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+}
+
+func (cfg *apiConfig) handlerProfileGet(w http.ResponseWriter, r *http.Request) {
+	dbUser, err := cfg.db.GetUserByHandle(r.Context(), r.PathValue("handle"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	profile := Profile{
+		Handle:      dbUser.Handle.String,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarURL:   dbUser.AvatarUrl,
+		CreatedAt:   dbUser.CreatedAt,
+	}
+
+	profile.FollowerCount, err = cfg.db.CountFollowers(r.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong counting followers", err)
+		return
+	}
+	profile.FollowingCount, err = cfg.db.CountFollowing(r.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong counting followed users", err)
+		return
+	}
+	profile.ChirpCount, err = cfg.db.CountChirpsByUser(r.Context(), dbUser.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong counting chirps", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (cfg *apiConfig) handlerProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+		AvatarURL   string `json:"avatar_url"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	if msg := validateProfile(params.Handle, params.DisplayName, params.Bio, params.AvatarURL); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	dbUser, err := cfg.db.UpdateProfile(r.Context(), database.UpdateProfileParams{
+		ID:          userID,
+		Handle:      sql.NullString{String: params.Handle, Valid: params.Handle != ""},
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		AvatarUrl:   params.AvatarURL,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the profile", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Author{
+		ID:          dbUser.ID,
+		Handle:      dbUser.Handle.String,
+		DisplayName: dbUser.DisplayName,
+		AvatarURL:   dbUser.AvatarUrl,
+	})
+}
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowing(w, r, true)
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowing(w, r, false)
+}
+
+func (cfg *apiConfig) setFollowing(w http.ResponseWriter, r *http.Request, follow bool) {
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followee, err := cfg.db.GetUserByHandle(r.Context(), r.PathValue("handle"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+	if followee.ID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+		return
+	}
+
+	if follow {
+		var added int64
+		added, err = cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+			FollowerID: userID,
+			FolloweeID: followee.ID,
+		})
+		if err == nil && added > 0 {
+			cfg.emitFollowed(r, userID, followee.ID)
+		}
+	} else {
+		_, err = cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+			FollowerID: userID,
+			FolloweeID: followee.ID,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the follow", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateProfile returns a message describing the first invalid field, or
+// an empty string when the profile is acceptable.
+func validateProfile(handle, displayName, bio, avatarURL string) string {
+	if handle != "" {
+		if !handlePattern.MatchString(handle) {
+			return "Handle must be 3-30 letters, digits or underscores"
+		}
+		if slices.Contains(reservedHandles, strings.ToLower(handle)) {
+			return "Handle is reserved"
+		}
+	}
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return "Display name is too long"
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return "Bio is too long"
+	}
+	if avatarURL != "" {
+		u, err := url.Parse(avatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return "Avatar URL must be an absolute http or https URL"
+		}
+	}
+	return ""
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// authorsByID loads the authors of a batch of chirps with a single query.
+func (cfg *apiConfig) authorsByID(r *http.Request, chirps []Chirp) (map[uuid.UUID]*Author, error) {
+	var ids []uuid.UUID
+	for _, c := range chirps {
+		if !slices.Contains(ids, c.UserID) {
+			ids = append(ids, c.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	rows, err := cfg.db.GetAuthorsByIDs(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+	authors := make(map[uuid.UUID]*Author, len(rows))
+	for _, row := range rows {
+		authors[row.ID] = &Author{
+			ID:          row.ID,
+			Handle:      row.Handle.String,
+			DisplayName: row.DisplayName,
+			AvatarURL:   row.AvatarUrl,
+		}
+	}
+	return authors, nil
+}
+
+// embedAuthors fills in Chirp.Author when the request asks for
+// ?embed=author.
+func (cfg *apiConfig) embedAuthors(r *http.Request, chirps []Chirp) error {
+	if r.URL.Query().Get("embed") != "author" {
+		return nil
+	}
+	authors, err := cfg.authorsByID(r, chirps)
+	if err != nil {
+		return err
+	}
+	for i := range chirps {
+		chirps[i].Author = authors[chirps[i].UserID]
+	}
+	return nil
+}
+
+func (cfg *apiConfig) emitFollowed(r *http.Request, followerID, followeeID uuid.UUID) {
+	follower, err := cfg.db.GetUserByID(r.Context(), followerID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading follower for webhook", "error", err)
+		return
+	}
+
+	type followed struct {
+		Follower Author    `json:"follower"`
+		UserID   uuid.UUID `json:"user_id"`
+	}
+	cfg.emitWebhookEvent(r.Context(), followeeID, eventUserFollowed, followed{
+		Follower: Author{
+			ID:          follower.ID,
+			Handle:      follower.Handle.String,
+			DisplayName: follower.DisplayName,
+			AvatarURL:   follower.AvatarUrl,
+		},
+		UserID: followeeID,
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateProfileHandle(t *testing.T) {
+	tests := []struct {
+		handle string
+		valid  bool
+	}{
+		{"", true},
+		{"saul_goodman", true},
+		{"Kim2", true},
+		{"ab", false},
+		{strings.Repeat("a", 31), false},
+		{"saul.goodman", false},
+		{"saul goodman", false},
+		{"sául", false},
+		{"me", false},
+		{"admin", false},
+		{"Admin", false},
+		{"EXPORT", false},
+		{"profile", false},
+	}
+	for _, tt := range tests {
+		if got := validateProfile(tt.handle, "", "", "") == ""; got != tt.valid {
+			t.Errorf("validateProfile(%q): got valid=%v, want %v", tt.handle, got, tt.valid)
+		}
+	}
+}
+
+func putProfile(t *testing.T, cfg *apiConfig, userID uuid.UUID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/api/users/profile", strings.NewReader(body))
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	cfg.handlerProfileUpdate(rec, req)
+	return rec
+}
+
+// Handles are unique regardless of case, which the database enforces with
+// an index on LOWER(handle).
+func TestProfileUpdateHandleTakenInAnyCase(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID := uuid.New()
+
+	if rec := putProfile(t, cfg, userID, `{"handle":"Me"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a reserved handle to be refused, got %d %s", rec.Code, rec.Body)
+	}
+
+	expectQuery(mock, "UpdateProfile").WithArgs(userID, "SAUL", "", "", "").
+		WillReturnError(&pq.Error{Code: "23505"})
+	if rec := putProfile(t, cfg, userID, `{"handle":"SAUL"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected a taken handle to conflict, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProfileGetLooksUpHandleCaseInsensitively(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Handle: sql.NullString{String: "saul", Valid: true}, DisplayName: "Saul Goodman"}
+	expectQuery(mock, "GetUserByHandle").WithArgs("SAUL").WillReturnRows(modelRows(user))
+	expectQuery(mock, "CountFollowers").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	expectQuery(mock, "CountFollowing").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	expectQuery(mock, "CountChirpsByUser").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/SAUL", nil)
+	req.SetPathValue("handle", "SAUL")
+	rec := httptest.NewRecorder()
+	cfg.handlerProfileGet(rec, req)
+
+	var profile Profile
+	if err := json.NewDecoder(rec.Body).Decode(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || profile.Handle != "saul" || profile.FollowerCount != 2 || profile.ChirpCount != 5 {
+		t.Fatalf("Unexpected profile: %d %+v", rec.Code, profile)
+	}
+}
+
+func follow(t *testing.T, cfg *apiConfig, method string, userID uuid.UUID, handle string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/api/users/"+handle+"/follow", nil)
+	req.SetPathValue("handle", handle)
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	if method == http.MethodDelete {
+		cfg.handlerUnfollow(rec, req)
+	} else {
+		cfg.handlerFollow(rec, req)
+	}
+	return rec
+}
+
+func TestFollowAndUnfollow(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	follower := database.User{ID: uuid.New(), Handle: sql.NullString{String: "kim", Valid: true}}
+	followee := database.User{ID: uuid.New(), Handle: sql.NullString{String: "saul", Valid: true}}
+
+	expectQuery(mock, "GetUserByHandle").WithArgs("saul").WillReturnRows(modelRows(followee))
+	expectExec(mock, "FollowUser").WithArgs(follower.ID, followee.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectQuery(mock, "GetUserByID").WithArgs(follower.ID).WillReturnRows(modelRows(follower))
+	expectQuery(mock, "GetEnabledWebhookEndpointsByUser").WithArgs(followee.ID).WillReturnRows(sqlmock.NewRows(nil))
+	if rec := follow(t, cfg, http.MethodPost, follower.ID, "saul"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the follow to succeed, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Following again is a no-op and doesn't notify the followee twice.
+	expectQuery(mock, "GetUserByHandle").WithArgs("saul").WillReturnRows(modelRows(followee))
+	expectExec(mock, "FollowUser").WithArgs(follower.ID, followee.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	if rec := follow(t, cfg, http.MethodPost, follower.ID, "saul"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the repeated follow to succeed, got %d %s", rec.Code, rec.Body)
+	}
+
+	expectQuery(mock, "GetUserByHandle").WithArgs("saul").WillReturnRows(modelRows(followee))
+	expectExec(mock, "UnfollowUser").WithArgs(follower.ID, followee.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	if rec := follow(t, cfg, http.MethodDelete, follower.ID, "saul"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the unfollow to succeed, got %d %s", rec.Code, rec.Body)
+	}
+
+	expectQuery(mock, "GetUserByHandle").WithArgs("kim").WillReturnRows(modelRows(follower))
+	if rec := follow(t, cfg, http.MethodPost, follower.ID, "kim"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected following yourself to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Authors are loaded with one query for the whole page, however many
+// chirps each of them wrote.
+func TestRetrieveChirpsEmbedsAuthorsInOneQuery(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	saul, kim := uuid.New(), uuid.New()
+	now := time.Now()
+	expectQuery(mock, "GetChirps").WillReturnRows(modelRows(
+		database.Chirp{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Body: "one", UserID: saul},
+		database.Chirp{ID: uuid.New(), CreatedAt: now.Add(time.Second), UpdatedAt: now, Body: "two", UserID: kim},
+		database.Chirp{ID: uuid.New(), CreatedAt: now.Add(2 * time.Second), UpdatedAt: now, Body: "three", UserID: saul},
+	))
+	expectQuery(mock, "GetAuthorsByIDs").WithArgs(pq.Array([]uuid.UUID{saul, kim})).WillReturnRows(modelRows(
+		database.GetAuthorsByIDsRow{ID: saul, Handle: sql.NullString{String: "saul", Valid: true}},
+		database.GetAuthorsByIDsRow{ID: kim, Handle: sql.NullString{String: "kim", Valid: true}},
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/?embed=author", nil)
+	rec := httptest.NewRecorder()
+	cfg.handlerRetrieveChirps(rec, req)
+
+	var chirps []Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&chirps); err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 3 {
+		t.Fatalf("Expected 3 chirps, got %d", len(chirps))
+	}
+	for _, c := range chirps {
+		if c.Author == nil || c.Author.ID != c.UserID {
+			t.Fatalf("Expected chirp %q to embed its author, got %+v", c.Body, c.Author)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerTokenRefresh(w http.ResponseWriter, r *http.Request) {
@@ -14,13 +15,37 @@ func (cfg *apiConfig) handlerTokenRefresh(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	refToken, err := cfg.db.GetRefreshToken(r.Context(), token)
-	if err != nil || refToken.ExpiresAt.Before(time.Now()) || refToken.RevokedAt.Valid {
+	selector, verifier, err := auth.SplitRefreshToken(token)
+	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
 		return
 	}
 
-	newToken, err := auth.MakeJWT(refToken.UserID, cfg.secretKey, time.Duration(3600)*time.Second)
+	refToken, err := cfg.db.GetRefreshToken(r.Context(), selector)
+	if err != nil || !auth.CheckTokenHash(verifier, refToken.TokenHash) ||
+		refToken.ExpiresAt.Before(time.Now()) || refToken.RevokedAt.Valid || refToken.ClientID.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+
+	err = cfg.db.TouchRefreshToken(r.Context(), database.TouchRefreshTokenParams{
+		Selector:  selector,
+		UserAgent: r.UserAgent(),
+		Ip:        clientIP(r),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the session", err)
+		return
+	}
+
+	// Look the role up again so role changes apply from the next refresh.
+	dbUser, err := cfg.db.GetUserByID(r.Context(), refToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
+		return
+	}
+
+	newToken, err := auth.MakeSessionJWT(dbUser.ID, refToken.ID, dbUser.Role, cfg.secretKey, time.Duration(3600)*time.Second)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the JWT token", err)
 		return
@@ -3,7 +3,7 @@ package main
 import "net/http"
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	cfg.fileserverHits.Store(0)
+	cfg.resetFileserverHits()
 	if cfg.pfmUser != "dev" {
 		respondWithError(w, http.StatusForbidden, "Reset endpoint is only available on dev platform", nil)
 		return
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
 	"net/http"
 )
 
@@ -12,7 +13,18 @@ func (cfg *apiConfig) handlerTokenRevoke(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(r.Context(), token)
+	selector, verifier, err := auth.SplitRefreshToken(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+
+	// Revoking a token that is already revoked changes nothing and isn't
+	// an error.
+	_, err = cfg.db.RevokeRefreshToken(r.Context(), database.RevokeRefreshTokenParams{
+		Selector:  selector,
+		TokenHash: auth.HashToken(verifier),
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the refresh token", err)
 		return
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net"
+	"net/http"
+	"time"
+)
+
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ClientID   *uuid.UUID `json:"client_id,omitempty"`
+	Scope      string     `json:"scope,omitempty"`
+}
+
+// sessionFromDB leaves ClientID nil for first-party sessions.
+func sessionFromDB(s database.RefreshToken) Session {
+	session := Session{
+		ID:         s.ID,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+		UserAgent:  s.UserAgent,
+		IP:         s.Ip,
+		Scope:      s.Scope,
+	}
+	if s.ClientID.Valid {
+		session.ClientID = &s.ClientID.UUID
+	}
+	return session
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbSessions, err := cfg.db.GetSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the sessions", err)
+		return
+	}
+
+	sessions := []Session{}
+	for _, s := range dbSessions {
+		sessions = append(sessions, sessionFromDB(s))
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerSessionRevoke ends one of the caller's sessions. Access tokens
+// name the session they were issued for, so they stop working too.
+func (cfg *apiConfig) handlerSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("sessionID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		ID:     id,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the session", err)
+		return
+	}
+
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	err = cfg.db.RevokeAllSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking the sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
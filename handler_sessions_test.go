@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionsList(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID, clientID := uuid.New(), uuid.New()
+	now := time.Now().UTC()
+	browser := database.RefreshToken{ID: uuid.New(), UserID: userID, ExpiresAt: now.Add(time.Hour), UserAgent: "Firefox", Ip: "203.0.113.7"}
+	app := database.RefreshToken{ID: uuid.New(), UserID: userID, ExpiresAt: now.Add(time.Hour), ClientID: uuid.NullUUID{UUID: clientID, Valid: true}, Scope: scopeChirpsWrite}
+
+	expectQuery(mock, "IsSessionActive").WithArgs(browser.ID, userID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	expectQuery(mock, "GetSessions").WithArgs(userID).WillReturnRows(modelRows(browser, app))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.Header.Set("Authorization", sessionBearer(t, userID, browser.ID))
+	rec := httptest.NewRecorder()
+	cfg.handlerSessionsList(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the sessions, got %d %s", rec.Code, rec.Body)
+	}
+
+	var sessions []Session
+	if err := json.NewDecoder(rec.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != browser.ID || sessions[0].ClientID != nil || sessions[0].UserAgent != "Firefox" {
+		t.Fatalf("Unexpected first-party session: %+v", sessions)
+	}
+	if sessions[1].ClientID == nil || *sessions[1].ClientID != clientID || sessions[1].Scope != scopeChirpsWrite {
+		t.Fatalf("Unexpected app session: %+v", sessions[1])
+	}
+}
+
+func TestSessionRevoke(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	expectExec(mock, "RevokeSession").WithArgs(sessionID, userID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/sessions/"+sessionID.String(), nil)
+	req.SetPathValue("sessionID", sessionID.String())
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	cfg.handlerSessionRevoke(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the session to be revoked, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// RevokeSession is scoped to the caller, so another user's session
+// matches no row and looks like one that doesn't exist.
+func TestSessionRevokeOtherUsersSession(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID, otherSession := uuid.New(), uuid.New()
+	expectExec(mock, "RevokeSession").WithArgs(otherSession, userID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/sessions/"+otherSession.String(), nil)
+	req.SetPathValue("sessionID", otherSession.String())
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	cfg.handlerSessionRevoke(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected another user's session to be left alone, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRevokedSessionAccessTokenIsRejected(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID, sessionID := uuid.New(), uuid.New()
+	expectQuery(mock, "IsSessionActive").WithArgs(sessionID, userID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.Header.Set("Authorization", sessionBearer(t, userID, sessionID))
+	rec := httptest.NewRecorder()
+	cfg.handlerSessionsList(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the revoked session's access token to be refused, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -1,22 +1,31 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/mr_rambling/chirpy/internal/auth"
 	"github.com/mr_rambling/chirpy/internal/database"
+	"log/slog"
 	"net/http"
+	"net/mail"
+	"strings"
 	"time"
 )
 
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	AccToken    string    `json:"token,omitempty"`
-	RefToken    string    `json:"refresh_token"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Email        string    `json:"email"`
+	AccToken     string    `json:"token,omitempty"`
+	RefToken     string    `json:"refresh_token"`
+	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	IsVerified   bool      `json:"is_email_verified"`
+	Role         string    `json:"role"`
+	PendingEmail string    `json:"pending_email,omitempty"`
 }
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -30,15 +39,32 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	params.Email, err = normalizeEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+	if !cfg.checkPassword(w, params.Password, params.Email) {
+		return
 	}
 
 	hashedPw, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong hashing the password", err)
+		return
 	}
 	dbUser, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{Email: params.Email, PasswordHash: hashedPw})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the user", err)
+		return
+	}
+
+	err = cfg.sendEmailVerification(r.Context(), dbUser)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sending verification email", "error", err)
 	}
 
 	u := User{
@@ -47,6 +73,8 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:   dbUser.UpdatedAt,
 		Email:       dbUser.Email,
 		IsChirpyRed: dbUser.IsChirpyRed,
+		IsVerified:  dbUser.EmailVerifiedAt.Valid,
+		Role:        dbUser.Role,
 	}
 	respondWithJSON(w, http.StatusCreated, u)
 }
@@ -65,36 +93,111 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !cfg.loginAllowed(w, r, params.Email) {
+		return
+	}
+
 	dbUser, err := cfg.db.GetUser(r.Context(), params.Email)
 	if err != nil {
+		cfg.recordLoginFailure(r, params.Email, uuid.NullUUID{})
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
 	match, err := auth.CheckPasswordHash(params.Password, dbUser.PasswordHash)
 	if err != nil || match != true {
+		cfg.recordLoginFailure(r, params.Email, uuid.NullUUID{UUID: dbUser.ID, Valid: true})
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	cfg.upgradePasswordHash(r.Context(), dbUser, params.Password)
+
+	cfg.completeLogin(w, r, dbUser)
+}
 
-	token, err := auth.MakeJWT(dbUser.ID, cfg.secretKey, time.Duration(3600)*time.Second)
+// upgradePasswordHash rehashes a just-verified password when the stored
+// hash uses weaker parameters or another algorithm. Failures are logged and
+// otherwise ignored; the old hash keeps working.
+func (cfg *apiConfig) upgradePasswordHash(ctx context.Context, dbUser database.User, password string) {
+	if !auth.NeedsRehash(dbUser.PasswordHash) {
+		return
+	}
+	hashedPw, err := auth.HashPassword(password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the JWT token", err)
+		slog.ErrorContext(ctx, "rehashing password", "user_id", dbUser.ID, "error", err)
+		return
+	}
+	err = cfg.db.UpdatePassword(ctx, database.UpdatePasswordParams{
+		ID:           dbUser.ID,
+		PasswordHash: hashedPw,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "saving rehashed password", "user_id", dbUser.ID, "error", err)
+	}
+}
+
+// completeLogin finishes a login once the user's first factor has been
+// checked: it either asks for a second factor or hands out tokens.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, dbUser database.User) {
+	if dbUser.TotpEnabled {
+		challenge, err := auth.MakeChallengeJWT(dbUser.ID, cfg.secretKey, 5*time.Minute)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the challenge token", err)
+			return
+		}
+
+		type challengeResponse struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}
+
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
 		return
 	}
 
+	err := cfg.accountLimiter.Reset(r.Context(), accountLockoutKey(dbUser.Email))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resetting login failures", "error", err)
+	}
+
+	cfg.respondWithSession(w, r, dbUser)
+}
+
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, dbUser database.User) {
 	refToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the refresh token", err)
 		return
 	}
 
+	selector, verifier, err := auth.SplitRefreshToken(refToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the refresh token", err)
+		return
+	}
+
 	expiry := time.Now().Add(60 * 24 * time.Hour)
-	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     refToken,
+	session, err := cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		Selector:  selector,
+		TokenHash: auth.HashToken(verifier),
 		UserID:    dbUser.ID,
 		ExpiresAt: expiry,
+		UserAgent: r.UserAgent(),
+		Ip:        clientIP(r),
 	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the refresh token", err)
+		return
+	}
+
+	token, err := auth.MakeSessionJWT(dbUser.ID, session.ID, dbUser.Role, cfg.secretKey, time.Duration(3600)*time.Second)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the JWT token", err)
+		return
+	}
 
 	u := User{
 		ID:          dbUser.ID,
@@ -104,56 +207,178 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		AccToken:    token,
 		RefToken:    refToken,
 		IsChirpyRed: dbUser.IsChirpyRed,
+		IsVerified:  dbUser.EmailVerifiedAt.Valid,
+		Role:        dbUser.Role,
 	}
+	cfg.metrics.Logins.Inc()
 	respondWithJSON(w, http.StatusOK, u)
 }
 
-func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request) {
+// handlerUserPatch updates only the fields present in the body. A new
+// password needs the current one, and a new email address takes effect
+// once it is confirmed through handlerEmailChangeConfirm.
+func (cfg *apiConfig) handlerUserPatch(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		AvatarURL       *string `json:"avatar_url"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	id, err := cfg.authenticate(r, scopeAccountWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authorization token missing", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	id, err := auth.ValidateJWT(token, cfg.secretKey)
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid authorization token", err)
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	dbUser, err := cfg.db.GetUserByID(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong decoding the JSON body", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the user", err)
 		return
 	}
 
-	hashedPw, err := auth.HashPassword(params.Password)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong hashing the password", err)
+	var hashedPw string
+	if params.Password != nil {
+		match, err := auth.CheckPasswordHash(params.CurrentPassword, dbUser.PasswordHash)
+		if err != nil || !match {
+			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect", err)
+			return
+		}
+		if !cfg.checkPassword(w, *params.Password, dbUser.Email) {
+			return
+		}
+		hashedPw, err = auth.HashPassword(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong hashing the password", err)
+			return
+		}
 	}
-	dbUser, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:           id,
-		Email:        params.Email,
-		PasswordHash: hashedPw,
+
+	profile := database.UpdateProfileParams{
+		ID:          id,
+		Handle:      dbUser.Handle,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarUrl:   dbUser.AvatarUrl,
+	}
+	profileChanged := false
+	if params.Handle != nil {
+		profile.Handle = sql.NullString{String: *params.Handle, Valid: *params.Handle != ""}
+		profileChanged = true
+	}
+	if params.DisplayName != nil {
+		profile.DisplayName = *params.DisplayName
+		profileChanged = true
+	}
+	if params.Bio != nil {
+		profile.Bio = *params.Bio
+		profileChanged = true
+	}
+	if params.AvatarURL != nil {
+		profile.AvatarUrl = *params.AvatarURL
+		profileChanged = true
+	}
+	if profileChanged {
+		msg := validateProfile(profile.Handle.String, profile.DisplayName, profile.Bio, profile.AvatarUrl)
+		if msg != "" {
+			respondWithError(w, http.StatusBadRequest, msg, nil)
+			return
+		}
+	}
+
+	var pendingEmail string
+	if params.Email != nil && !strings.EqualFold(*params.Email, dbUser.Email) {
+		pendingEmail, err = normalizeEmail(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+			return
+		}
+		_, err := cfg.db.GetUser(r.Context(), pendingEmail)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email address is already in use", nil)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong checking the email address", err)
+			return
+		}
+	}
+
+	if profileChanged {
+		dbUser, err = cfg.db.UpdateProfile(r.Context(), profile)
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the profile", err)
+			return
+		}
+	}
+
+	if hashedPw != "" {
+		err = cfg.db.UpdatePassword(r.Context(), database.UpdatePasswordParams{
+			ID:           id,
+			PasswordHash: hashedPw,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the password", err)
+			return
+		}
+		// Sign out everywhere else, as a password reset does, but keep the
+		// session that made the change.
+		err = cfg.db.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+			UserID: id,
+			ID:     cfg.requestSessionID(r),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong revoking other sessions", err)
+			return
+		}
+	}
+
+	if pendingEmail != "" {
+		err = cfg.sendEmailChange(r.Context(), id, pendingEmail)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong sending the confirmation email", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, User{
+		ID:           dbUser.ID,
+		CreatedAt:    dbUser.CreatedAt,
+		UpdatedAt:    dbUser.UpdatedAt,
+		Email:        dbUser.Email,
+		IsChirpyRed:  dbUser.IsChirpyRed,
+		IsVerified:   dbUser.EmailVerifiedAt.Valid,
+		Role:         dbUser.Role,
+		PendingEmail: pendingEmail,
 	})
+}
+
+// normalizeEmail accepts a single bare address such as saul@bettercall.com
+// and returns it without surrounding space. Display names and anything
+// net/mail can't parse are refused, as the address ends up in mail headers.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong updating the user", err)
-		return
+		return "", err
 	}
-
-	u := User{
-		ID:          id,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
+	if addr.Name != "" || addr.Address != email {
+		return "", errors.New("email must be a bare address")
 	}
-	respondWithJSON(w, http.StatusOK, u)
+	return email, nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{" saul@bettercall.com ", "saul@bettercall.com", true},
+		{"Saul Goodman <saul@bettercall.com>", "", false},
+		{"saul@bettercall.com\r\nBcc: kim@wexlermcgill.com", "", false},
+		{"saul@bettercall.com, kim@wexlermcgill.com", "", false},
+		{"not an address", "", false},
+	}
+	for _, c := range cases {
+		got, err := normalizeEmail(c.in)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("normalizeEmail(%q) = %q, %v; want %q, ok=%v", c.in, got, err, c.want, c.ok)
+		}
+	}
+}
+
+// PUT /api/users used to overwrite the email and password with nothing but
+// an access token. It now follows the PATCH rules.
+func TestUserPutNeedsCurrentPassword(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", PasswordHash: hash}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+
+	req := httptest.NewRequest(http.MethodPut, "/api/users",
+		strings.NewReader(`{"email":"attacker@example.com","password":"a brand new passphrase"}`))
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes(".").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the password change to need the current password, got %d %s", rec.Code, rec.Body)
+	}
+	if msgs := cfg.mailer.(*mailer.MemoryMailer).Messages(); len(msgs) != 0 {
+		t.Fatalf("Expected no mail, got %+v", msgs)
+	}
+}
+
+func TestUserPutEmailWaitsForConfirmation(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", EmailVerifiedAt: sql.NullTime{Valid: true}}
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "GetUser").WithArgs("jimmy@example.com").WillReturnError(sql.ErrNoRows)
+	expectExec(mock, "DeleteUnusedUserTokens").WithArgs(user.ID, tokenPurposeEmailChange).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectQuery(mock, "CreateUserToken").WillReturnRows(modelRows(database.UserToken{ID: uuid.New(), UserID: user.ID}))
+
+	req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"email":"jimmy@example.com"}`))
+	req.Header.Set("Authorization", bearer(t, user.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes(".").ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the change to be accepted, got %d %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `"email":"saul@bettercall.com"`) || !strings.Contains(body, `"pending_email":"jimmy@example.com"`) {
+		t.Fatalf("Expected the old address to stay until confirmed, got %s", body)
+	}
+	msgs := cfg.mailer.(*mailer.MemoryMailer).Messages()
+	if len(msgs) != 1 || msgs[0].To != "jimmy@example.com" {
+		t.Fatalf("Expected a confirmation mail to the new address, got %+v", msgs)
+	}
+}
+
+// Changing the password signs out every other session but keeps the one
+// that made the change.
+func TestUserPatchPasswordRevokesOtherSessions(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com", PasswordHash: hash}
+	sessionID := uuid.New()
+	expectQuery(mock, "IsSessionActive").WithArgs(sessionID, user.ID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectExec(mock, "UpdatePassword").WithArgs(user.ID, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectExec(mock, "RevokeOtherSessions").WithArgs(user.ID, sessionID).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/users",
+		strings.NewReader(`{"current_password":"correct horse battery staple","password":"a brand new passphrase"}`))
+	req.Header.Set("Authorization", sessionBearer(t, user.ID, sessionID))
+	rec := httptest.NewRecorder()
+	cfg.routes(".").ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the password to change, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// A confirmation that loses the race for the address rolls back, so its
+// token can still be used once the conflict is sorted out.
+func TestEmailChangeConfirmConflictKeepsToken(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	token := database.UserToken{ID: uuid.New(), UserID: user.ID, Purpose: tokenPurposeEmailChange, Payload: "jimmy@example.com"}
+	mock.ExpectBegin()
+	expectQuery(mock, "ConsumeUserToken").WillReturnRows(modelRows(token))
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "ChangeEmail").WithArgs(user.ID, "jimmy@example.com").WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/email/change/confirm", strings.NewReader(`{"token":"secret"}`))
+	rec := httptest.NewRecorder()
+	cfg.handlerEmailChangeConfirm(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected the change to conflict, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserFollowed = "user.followed"
+
+	webhookDeliveriesLimit = 50
+	maxWebhookEndpoints    = 10
+)
+
+var webhookEventTypes = []string{eventChirpCreated, eventChirpDeleted, eventUserFollowed}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID  `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	Secret              string     `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+}
+
+func webhookEndpointFromDB(e database.WebhookEndpoint) WebhookEndpoint {
+	endpoint := WebhookEndpoint{
+		ID:                  e.ID,
+		CreatedAt:           e.CreatedAt,
+		URL:                 e.Url,
+		Events:              strings.Fields(e.Events),
+		Enabled:             e.Enabled,
+		ConsecutiveFailures: e.ConsecutiveFailures,
+	}
+	if e.DisabledAt.Valid {
+		endpoint.DisabledAt = &e.DisabledAt.Time
+	}
+	return endpoint
+}
+
+func webhookDeliveryFromDB(d database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:          d.ID,
+		CreatedAt:   d.CreatedAt,
+		EventType:   d.EventType,
+		Payload:     d.Payload,
+		Status:      d.Status,
+		Attempts:    d.Attempts,
+		NextAttempt: d.NextAttemptAt,
+	}
+	if d.DeliveredAt.Valid {
+		delivery.DeliveredAt = &d.DeliveredAt.Time
+	}
+	return delivery
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	userID, err := cfg.authenticate(r, scopeWebhooksWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
+		return
+	}
+
+	u, err := url.Parse(params.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && !(cfg.pfmUser == "dev" && u.Scheme == "http")) {
+		respondWithError(w, http.StatusBadRequest, "Webhook URL must be an absolute https URL", err)
+		return
+	}
+
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required", nil)
+		return
+	}
+	for _, event := range params.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			respondWithError(w, http.StatusBadRequest, "Unsupported event: "+event, nil)
+			return
+		}
+	}
+
+	count, err := cfg.db.CountWebhookEndpointsByUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong counting the webhook endpoints", err)
+		return
+	}
+	if count >= maxWebhookEndpoints {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("At most %d webhook endpoints are allowed", maxWebhookEndpoints), nil)
+		return
+	}
+
+	token, err := auth.MakeUserToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong creating the signing secret", err)
+		return
+	}
+	secret := "whsec_" + token
+
+	dbEndpoint, err := cfg.db.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		UserID: userID,
+		Url:    u.String(),
+		Secret: secret,
+		Events: strings.Join(params.Events, " "),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong saving the webhook endpoint", err)
+		return
+	}
+
+	// The signing secret is only ever returned here.
+	endpoint := webhookEndpointFromDB(dbEndpoint)
+	endpoint.Secret = secret
+	respondWithJSON(w, http.StatusCreated, endpoint)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbEndpoints, err := cfg.db.GetWebhookEndpointsByUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook endpoints", err)
+		return
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, e := range dbEndpoints {
+		endpoints = append(endpoints, webhookEndpointFromDB(e))
+	}
+	respondWithJSON(w, http.StatusOK, endpoints)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeWebhooksWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:     id,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong deleting the webhook endpoint", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookEndpointEnable turns an endpoint back on after it was
+// disabled for failing too often.
+func (cfg *apiConfig) handlerWebhookEndpointEnable(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeWebhooksWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	enabled, err := cfg.db.EnableWebhookEndpoint(r.Context(), database.EnableWebhookEndpointParams{
+		ID:     id,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong enabling the webhook endpoint", err)
+		return
+	}
+	if enabled == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && endpoint.UserID != userID) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook endpoint", err)
+		return
+	}
+
+	dbDeliveries, err := cfg.db.GetWebhookDeliveriesByEndpoint(r.Context(), database.GetWebhookDeliveriesByEndpointParams{
+		EndpointID: id,
+		Limit:      webhookDeliveriesLimit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the deliveries", err)
+		return
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, d := range dbDeliveries {
+		deliveries = append(deliveries, webhookDeliveryFromDB(d))
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// handlerWebhookDeliveryGet returns one delivery with the log of every
+// attempt made to send it.
+func (cfg *apiConfig) handlerWebhookDeliveryGet(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID", err)
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticate(r, scopeAccountRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(r.Context(), endpointID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && endpoint.UserID != userID) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the webhook endpoint", err)
+		return
+	}
+
+	d, err := cfg.db.GetWebhookDelivery(r.Context(), deliveryID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && d.EndpointID != endpointID) {
+		respondWithError(w, http.StatusNotFound, "Delivery not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the delivery", err)
+		return
+	}
+
+	dbAttempts, err := cfg.db.GetWebhookDeliveryAttempts(r.Context(), deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong retrieving the delivery attempts", err)
+		return
+	}
+
+	type attempt struct {
+		AttemptedAt    time.Time `json:"attempted_at"`
+		ResponseStatus int32     `json:"response_status"`
+		DurationMs     int32     `json:"duration_ms"`
+		Error          string    `json:"error,omitempty"`
+	}
+	type response struct {
+		WebhookDelivery
+		AttemptLog []attempt `json:"attempt_log"`
+	}
+
+	resp := response{WebhookDelivery: webhookDeliveryFromDB(d), AttemptLog: []attempt{}}
+	for _, a := range dbAttempts {
+		resp.AttemptLog = append(resp.AttemptLog, attempt{
+			AttemptedAt:    a.AttemptedAt,
+			ResponseStatus: a.ResponseStatus,
+			DurationMs:     a.DurationMs,
+			Error:          a.Error,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
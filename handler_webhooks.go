@@ -1,43 +1,191 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
+const (
+	subscriptionStatusActive    = "active"
+	subscriptionStatusCancelled = "cancelled"
+	subscriptionStatusExpired   = "expired"
+
+	// Used when Polka doesn't send an expiry with an upgrade or renewal.
+	defaultSubscriptionPeriod = 30 * 24 * time.Hour
+
+	webhookSignatureTolerance = 5 * time.Minute
+	maxWebhookBodyBytes       = 64 << 10
+)
+
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID    uuid.UUID  `json:"user_id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	} `json:"data"`
+}
+
+// handlerWebhooks stores each Polka event in the webhook_events inbox
+// before processing it. Polka retries deliveries, so an event ID that has
+// already been received is acknowledged without being applied again.
 func (cfg *apiConfig) handlerWebhooks(w http.ResponseWriter, r *http.Request) {
-	type webhookPayload struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
+	keyStr, err := auth.GetAPIKey(r.Header)
+	if err != nil || cfg.polkaKey == "" || !auth.CheckTokenHash(keyStr, auth.HashToken(cfg.polkaKey)) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized user", err)
+		return
 	}
 
-	var payload webhookPayload
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&payload)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong decoding the JSON body", err)
+		respondWithError(w, http.StatusBadRequest, "Something went wrong reading the request body", err)
 		return
 	}
 
-	if payload.Event != "user.upgraded" {
-		respondWithError(w, http.StatusNoContent, "Unsupported event type", nil)
+	// Config validation only lets the signing secret be empty in dev.
+	if cfg.polkaSigningSecret != "" {
+		err = auth.VerifyWebhookSignature(
+			cfg.polkaSigningSecret,
+			r.Header.Get("X-Polka-Timestamp"),
+			r.Header.Get("X-Polka-Signature"),
+			body,
+			time.Now(),
+			webhookSignatureTolerance,
+		)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", err)
+			return
+		}
+	}
+
+	var payload polkaEvent
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong decoding the JSON body", err)
 		return
 	}
 
-	err = cfg.db.UpgradeChirpyRed(r.Context(), payload.Data.UserID)
+	eventID, err := polkaEventID(r.Header, payload, body)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Something went wrong upgrading the user to premium", err)
+		respondWithError(w, http.StatusBadRequest, "Missing webhook event ID", err)
 		return
 	}
 
-	keyStr, err := auth.GetAPIKey(r.Header)
-	if err != nil || keyStr != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized user", err)
+	event, err := cfg.db.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		Provider:  webhookProviderPolka,
+		EventID:   eventID,
+		EventType: payload.Event,
+		Payload:   body,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong storing the webhook event", err)
+		return
 	}
 
-	respondWithJSON(w, http.StatusNoContent, "")
+	err = cfg.processWebhookEvent(r.Context(), event.ID)
+	if err != nil {
+		// The event is stored and will be retried in the background.
+		slog.ErrorContext(r.Context(), "processing webhook event", "event_id", event.ID, "error", err)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// polkaEventID identifies an event for deduplication. Events without an
+// ID fall back to a hash of the signed timestamp and the body: two real
+// events can share a body, but not the moment Polka signed them.
+func polkaEventID(header http.Header, payload polkaEvent, body []byte) (string, error) {
+	if payload.ID != "" {
+		return payload.ID, nil
+	}
+	if id := header.Get("X-Polka-Event-Id"); id != "" {
+		return id, nil
+	}
+	timestamp := header.Get("X-Polka-Timestamp")
+	if timestamp == "" {
+		return "", errors.New("event has no ID and no X-Polka-Timestamp header")
+	}
+	sum := sha256.Sum256(append([]byte(timestamp+"."), body...))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// applyPolkaEvent updates the user's subscription for a webhook event.
+// Unknown events are ignored so Polka doesn't keep retrying them, and so
+// are events received before the last one applied to the subscription, so
+// a retried upgrade can't undo a later downgrade. Housekeeping expiring
+// the subscription doesn't count as an event.
+func applyPolkaEvent(ctx context.Context, q *database.Queries, payload polkaEvent, receivedAt time.Time) error {
+	var status string
+	switch payload.Event {
+	case "user.upgraded", "subscription.renewed":
+		status = subscriptionStatusActive
+	case "user.downgraded":
+		status = subscriptionStatusCancelled
+	case "subscription.expired":
+		status = subscriptionStatusExpired
+	default:
+		return nil
+	}
+
+	userID := payload.Data.UserID
+	_, err := q.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", errUserNotFound, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	sub, err := q.GetSubscriptionForUpdate(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && sub.LastEventAt.Valid && receivedAt.Before(sub.LastEventAt.Time) {
+		slog.InfoContext(ctx, "skipping stale webhook event", "event", payload.Event, "user_id", userID)
+		return nil
+	}
+
+	if status == subscriptionStatusActive {
+		expiresAt := time.Now().UTC().Add(defaultSubscriptionPeriod)
+		if payload.Data.ExpiresAt != nil {
+			expiresAt = payload.Data.ExpiresAt.UTC()
+		}
+		_, err = q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:      userID,
+			Status:      status,
+			ExpiresAt:   expiresAt,
+			LastEventAt: sql.NullTime{Time: receivedAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return q.UpgradeChirpyRed(ctx, userID)
+	}
+
+	_, err = q.SetSubscriptionStatus(ctx, database.SetSubscriptionStatusParams{
+		UserID:      userID,
+		Status:      status,
+		LastEventAt: sql.NullTime{Time: receivedAt, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	return q.DowngradeChirpyRed(ctx, userID)
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolkaKey = "f271c81ff7084ee5b99a5091b42d486e"
+
+func TestPolkaEventID(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	header := http.Header{}
+	if _, err := polkaEventID(header, polkaEvent{}, body); err == nil {
+		t.Fatalf("Expected an event without an ID or timestamp to be rejected")
+	}
+
+	header.Set("X-Polka-Timestamp", "1760000000")
+	first, err := polkaEventID(header, polkaEvent{}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Set("X-Polka-Timestamp", "1760000060")
+	second, err := polkaEventID(header, polkaEvent{}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("Expected events with the same body at different times to get different IDs, both got %q", first)
+	}
+
+	if id, _ := polkaEventID(header, polkaEvent{ID: "evt_123"}, body); id != "evt_123" {
+		t.Fatalf("Expected the provider's event ID, got %q", id)
+	}
+}
+
+func TestWebhookDuplicateIsAcknowledged(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	cfg.polkaKey = testPolkaKey
+	expectQuery(mock, "CreateWebhookEvent").WithArgs(webhookProviderPolka, "evt_123", "user.upgraded", sqlmock.AnyArg()).
+		WillReturnError(sql.ErrNoRows)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks",
+		strings.NewReader(`{"id":"evt_123","event":"user.upgraded","data":{"user_id":"`+uuid.NewString()+`"}}`))
+	req.Header.Set("Authorization", "ApiKey "+testPolkaKey)
+	rec := httptest.NewRecorder()
+	cfg.handlerWebhooks(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the duplicate to be acknowledged, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProcessWebhookEventRollsBackBeforeMarkingFailed(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	event := database.WebhookEvent{
+		ID:         uuid.New(),
+		ReceivedAt: time.Now().UTC(),
+		Provider:   webhookProviderPolka,
+		EventID:    "evt_123",
+		EventType:  "user.upgraded",
+		Payload:    []byte(`{"event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`),
+		Status:     webhookStatusPending,
+	}
+
+	mock.ExpectBegin()
+	expectQuery(mock, "LockWebhookEvent").WithArgs(event.ID).WillReturnRows(modelRows(event))
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "GetSubscriptionForUpdate").WithArgs(user.ID).WillReturnError(sql.ErrNoRows)
+	expectQuery(mock, "UpsertSubscription").WillReturnRows(modelRows(database.Subscription{ID: uuid.New(), UserID: user.ID}))
+	expectExec(mock, "UpgradeChirpyRed").WithArgs(user.ID).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	expectExec(mock, "MarkWebhookEventFailed").WithArgs(event.ID, "connection reset", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := cfg.processWebhookEvent(t.Context(), event.ID); err == nil {
+		t.Fatalf("Expected the apply error to be returned")
+	}
+}
+
+// Replaying an upgrade that arrived before a later downgrade must not make
+// the user Chirpy Red again.
+func TestWebhookReplaySkipsStaleEvent(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	received := time.Now().UTC().Add(-time.Hour)
+	event := database.WebhookEvent{
+		ID:         uuid.New(),
+		ReceivedAt: received,
+		Provider:   webhookProviderPolka,
+		EventID:    "evt_123",
+		EventType:  "user.upgraded",
+		Payload:    []byte(`{"event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`),
+		Status:     webhookStatusPending,
+	}
+	sub := database.Subscription{
+		ID:          uuid.New(),
+		UpdatedAt:   received.Add(time.Minute),
+		UserID:      user.ID,
+		Status:      subscriptionStatusCancelled,
+		LastEventAt: sql.NullTime{Time: received.Add(time.Minute), Valid: true},
+	}
+
+	expectQuery(mock, "ResetWebhookEvent").WithArgs(event.ID).WillReturnRows(modelRows(event))
+	mock.ExpectBegin()
+	expectQuery(mock, "LockWebhookEvent").WithArgs(event.ID).WillReturnRows(modelRows(event))
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "GetSubscriptionForUpdate").WithArgs(user.ID).WillReturnRows(modelRows(sub))
+	expectExec(mock, "MarkWebhookEventProcessed").WithArgs(event.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	processed := event
+	processed.Status = webhookStatusProcessed
+	expectQuery(mock, "GetWebhookEvent").WithArgs(event.ID).WillReturnRows(modelRows(processed))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/webhooks/events/"+event.ID.String()+"/replay", nil)
+	req.SetPathValue("eventID", event.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerAdminWebhookEventReplay(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"processed"`) {
+		t.Fatalf("Expected the replayed event to be processed without effect, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Housekeeping expiring a subscription touches updated_at but isn't an
+// event, so a renewal received before it ran still applies.
+func TestWebhookRenewalAppliesAfterExpiry(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	user := database.User{ID: uuid.New(), Email: "saul@bettercall.com"}
+	received := time.Now().UTC().Add(-time.Minute)
+	event := database.WebhookEvent{
+		ID:         uuid.New(),
+		ReceivedAt: received,
+		Provider:   webhookProviderPolka,
+		EventID:    "evt_456",
+		EventType:  "subscription.renewed",
+		Payload:    []byte(`{"event":"subscription.renewed","data":{"user_id":"` + user.ID.String() + `"}}`),
+		Status:     webhookStatusPending,
+	}
+	sub := database.Subscription{
+		ID:          uuid.New(),
+		UpdatedAt:   time.Now().UTC(),
+		UserID:      user.ID,
+		Status:      subscriptionStatusExpired,
+		LastEventAt: sql.NullTime{Time: received.Add(-30 * 24 * time.Hour), Valid: true},
+	}
+
+	mock.ExpectBegin()
+	expectQuery(mock, "LockWebhookEvent").WithArgs(event.ID).WillReturnRows(modelRows(event))
+	expectQuery(mock, "GetUserByID").WithArgs(user.ID).WillReturnRows(modelRows(user))
+	expectQuery(mock, "GetSubscriptionForUpdate").WithArgs(user.ID).WillReturnRows(modelRows(sub))
+	expectQuery(mock, "UpsertSubscription").WithArgs(user.ID, subscriptionStatusActive, sqlmock.AnyArg(), received).
+		WillReturnRows(modelRows(sub))
+	expectExec(mock, "UpgradeChirpyRed").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectExec(mock, "MarkWebhookEventProcessed").WithArgs(event.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := cfg.processWebhookEvent(t.Context(), event.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
 )
 
+const apiKeyPrefix = "chirpy"
+
 func GetAPIKey(headers http.Header) (string, error) {
 	keyStr := headers.Get("Authorization")
 
@@ -15,3 +19,27 @@ func GetAPIKey(headers http.Header) (string, error) {
 	keyStr = strings.ReplaceAll(keyStr, "ApiKey ", "")
 	return keyStr, nil
 }
+
+// MakeAPIKey returns a personal API key of the form chirpy_<selector>_<secret>.
+// The selector is stored in plaintext for lookups and the secret is hashed.
+func MakeAPIKey() (key, selector, secret string, err error) {
+	sel := make([]byte, 6)
+	if _, err := rand.Read(sel); err != nil {
+		return "", "", "", err
+	}
+	sec := make([]byte, 32)
+	if _, err := rand.Read(sec); err != nil {
+		return "", "", "", err
+	}
+	selector = hex.EncodeToString(sel)
+	secret = hex.EncodeToString(sec)
+	return apiKeyPrefix + "_" + selector + "_" + secret, selector, secret, nil
+}
+
+func SplitAPIKey(key string) (selector, secret string, err error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("malformed API key")
+	}
+	return parts[1], parts[2], nil
+}
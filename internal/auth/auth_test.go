@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"testing"
 	"time"
@@ -33,12 +35,54 @@ func TestHashAndCheckPassword(t *testing.T) {
 	}
 }
 
+func TestNeedsRehash(t *testing.T) {
+	password := "my_secure_password_123!"
+
+	weak, err := argon2id.CreateHash(password, &argon2id.Params{
+		Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	})
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if !NeedsRehash(weak) {
+		t.Fatalf("Hash with weaker parameters should need a rehash")
+	}
+
+	current, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if NeedsRehash(current) {
+		t.Fatalf("Hash with current parameters should not need a rehash")
+	}
+}
+
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	password := "my_secure_password_123!"
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	match, err := CheckPasswordHash(password, string(hash))
+	if err != nil || !match {
+		t.Fatalf("bcrypt hash should match, got %v %v", match, err)
+	}
+	match, err = CheckPasswordHash("wrong_password", string(hash))
+	if err != nil || match {
+		t.Fatalf("Wrong password matched the bcrypt hash, got %v %v", match, err)
+	}
+	if !NeedsRehash(string(hash)) {
+		t.Fatalf("bcrypt hash should always need a rehash")
+	}
+}
+
 func TestMakeAndValidateJWT(t *testing.T) {
 	userID := uuid.New()
 	tokenSecret := "my_secret_key"
 	expiresIn := time.Minute * 15
 
-	token, err := MakeJWT(userID, tokenSecret, expiresIn)
+	token, err := MakeJWT(userID, "user", tokenSecret, expiresIn)
 	if err != nil {
 		t.Fatalf("Error making JWT: %v", err)
 	}
@@ -58,12 +102,55 @@ func TestMakeAndValidateJWT(t *testing.T) {
 	}
 }
 
+func TestJWTCarriesRole(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "my_secret_key"
+
+	token, err := MakeJWT(userID, "admin", tokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making JWT: %v", err)
+	}
+
+	claims, err := ValidateJWTClaims(token, tokenSecret)
+	if err != nil {
+		t.Fatalf("Error validating JWT: %v", err)
+	}
+	if claims.UserID != userID || claims.Role != "admin" {
+		t.Fatalf("Claims do not match. Got %+v", claims)
+	}
+}
+
+func TestJWTCarriesSession(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	tokenSecret := "my_secret_key"
+
+	token, err := MakeSessionJWT(userID, sessionID, "user", tokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making JWT: %v", err)
+	}
+	claims, err := ValidateJWTClaims(token, tokenSecret)
+	if err != nil {
+		t.Fatalf("Error validating JWT: %v", err)
+	}
+	if claims.UserID != userID || claims.SessionID != sessionID {
+		t.Fatalf("Claims do not match. Got %+v", claims)
+	}
+
+	token, err = MakeJWT(userID, "user", tokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making JWT: %v", err)
+	}
+	if claims, err := ValidateJWTClaims(token, tokenSecret); err != nil || claims.SessionID != uuid.Nil {
+		t.Fatalf("Expected no session, got %+v %v", claims, err)
+	}
+}
+
 func TestValidateJWTExpiredToken(t *testing.T) {
 	userID := uuid.New()
 	tokenSecret := "my_secret_key"
 	expiresIn := -time.Minute * 1 // Token already expired
 
-	token, err := MakeJWT(userID, tokenSecret, expiresIn)
+	token, err := MakeJWT(userID, "user", tokenSecret, expiresIn)
 	if err != nil {
 		t.Fatalf("Error making JWT: %v", err)
 	}
@@ -86,3 +173,196 @@ func TestBearerTokenExtraction(t *testing.T) {
 		t.Fatalf("Extracted token does not match expected. Got %v, want %v", token, "valid_token_string")
 	}
 }
+
+func TestSplitRefreshToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Error making refresh token: %v", err)
+	}
+
+	selector, verifier, err := SplitRefreshToken(token)
+	if err != nil {
+		t.Fatalf("Error splitting refresh token: %v", err)
+	}
+	if selector+verifier != token {
+		t.Fatalf("Selector and verifier do not rebuild the token. Got %v, want %v", selector+verifier, token)
+	}
+
+	tokenHash := HashToken(verifier)
+	if tokenHash == verifier {
+		t.Fatalf("Hash should not equal the verifier")
+	}
+	if !CheckTokenHash(verifier, tokenHash) {
+		t.Fatalf("Verifier did not match its hash")
+	}
+	if CheckTokenHash(verifier+"0", tokenHash) {
+		t.Fatalf("Wrong verifier matched the hash")
+	}
+
+	_, _, err = SplitRefreshToken("not-a-token")
+	if err == nil {
+		t.Fatalf("Expected error when splitting malformed token, got none")
+	}
+}
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B SHA-1 vectors, truncated to six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, c := range cases {
+		got, err := GenerateTOTP(secret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("Error generating TOTP: %v", err)
+		}
+		if got != c.want {
+			t.Fatalf("TOTP at %d does not match. Got %v, want %v", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret, err := MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("Error making TOTP secret: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, err := GenerateTOTP(secret, now)
+	if err != nil {
+		t.Fatalf("Error generating TOTP: %v", err)
+	}
+
+	counter, ok := ValidateTOTP(secret, code, now.Add(30*time.Second))
+	if !ok {
+		t.Fatalf("Code from the previous step should be accepted")
+	}
+	if counter != now.Unix()/30 {
+		t.Fatalf("Counter does not match. Got %v, want %v", counter, now.Unix()/30)
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now.Add(2*time.Minute)); ok {
+		t.Fatalf("Code outside the window should be rejected")
+	}
+}
+
+func TestChallengeJWTNotAccessToken(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "my_secret_key"
+
+	challenge, err := MakeChallengeJWT(userID, tokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making challenge JWT: %v", err)
+	}
+
+	if _, err := ValidateJWT(challenge, tokenSecret); err == nil {
+		t.Fatalf("Expected error when using a challenge token as an access token, got none")
+	}
+
+	returnedUserID, err := ValidateChallengeJWT(challenge, tokenSecret)
+	if err != nil {
+		t.Fatalf("Error validating challenge JWT: %v", err)
+	}
+	if returnedUserID != userID {
+		t.Fatalf("Returned user ID does not match original. Got %v, want %v", returnedUserID, userID)
+	}
+}
+
+func TestOAuthJWTScopes(t *testing.T) {
+	userID := uuid.New()
+	clientID := uuid.New()
+	sessionID := uuid.New()
+	tokenSecret := "my_secret_key"
+
+	token, err := MakeOAuthJWT(userID, clientID, sessionID, "chirps:read chirps:write", tokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making OAuth JWT: %v", err)
+	}
+
+	if _, err := ValidateJWT(token, tokenSecret); err == nil {
+		t.Fatalf("Expected error when using an OAuth token as a first-party token, got none")
+	}
+
+	claims, err := ValidateOAuthJWT(token, tokenSecret)
+	if err != nil {
+		t.Fatalf("Error validating OAuth JWT: %v", err)
+	}
+	if claims.UserID != userID || claims.ClientID != clientID || claims.SessionID != sessionID {
+		t.Fatalf("Claims do not match. Got %+v", claims)
+	}
+	if !claims.HasScope("chirps:write") || claims.HasScope("admin") {
+		t.Fatalf("Unexpected scopes: %v", claims.Scopes)
+	}
+}
+
+func TestCheckPKCE(t *testing.T) {
+	// RFC 7636 appendix B example.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !CheckPKCE(verifier, challenge) {
+		t.Fatalf("Verifier did not match the challenge")
+	}
+	if CheckPKCE(verifier[1:]+"A", challenge) {
+		t.Fatalf("Wrong verifier matched the challenge")
+	}
+}
+
+func TestMakeAndSplitAPIKey(t *testing.T) {
+	key, selector, secret, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("Error making API key: %v", err)
+	}
+
+	header := http.Header{}
+	header.Add("Authorization", "ApiKey "+key)
+	got, err := GetAPIKey(header)
+	if err != nil {
+		t.Fatalf("Error extracting API key: %v", err)
+	}
+
+	gotSelector, gotSecret, err := SplitAPIKey(got)
+	if err != nil {
+		t.Fatalf("Error splitting API key: %v", err)
+	}
+	if gotSelector != selector || gotSecret != secret {
+		t.Fatalf("Split key does not match. Got %v %v, want %v %v", gotSelector, gotSecret, selector, secret)
+	}
+
+	if _, _, err := SplitAPIKey("f271c81ff7084ee5b99a5091b42d486e"); err == nil {
+		t.Fatalf("Expected error when splitting a key without the chirpy prefix, got none")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"event":"user.upgraded"}`)
+	now := time.Unix(1700000000, 0)
+
+	sig := SignWebhook(secret, now.Unix(), body)
+	ts := "1700000000"
+
+	if err := VerifyWebhookSignature(secret, ts, sig, body, now, 5*time.Minute); err != nil {
+		t.Fatalf("Valid signature rejected: %v", err)
+	}
+	if err := VerifyWebhookSignature(secret, ts, sig, []byte(`{"event":"user.downgraded"}`), now, 5*time.Minute); err != ErrSignatureInvalid {
+		t.Fatalf("Expected ErrSignatureInvalid for a modified body, got %v", err)
+	}
+	if err := VerifyWebhookSignature("other", ts, sig, body, now, 5*time.Minute); err != ErrSignatureInvalid {
+		t.Fatalf("Expected ErrSignatureInvalid for the wrong secret, got %v", err)
+	}
+	if err := VerifyWebhookSignature(secret, ts, sig, body, now.Add(10*time.Minute), 5*time.Minute); err != ErrSignatureExpired {
+		t.Fatalf("Expected ErrSignatureExpired for an old timestamp, got %v", err)
+	}
+	if err := VerifyWebhookSignature(secret, ts, "v1=deadbeef, "+sig, body, now, 5*time.Minute); err != nil {
+		t.Fatalf("Signature list containing a valid signature rejected: %v", err)
+	}
+}
@@ -6,13 +6,79 @@ import (
 	"time"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+const (
+	accessTokenIssuer    = "chirpy"
+	challengeTokenIssuer = "chirpy-2fa"
+)
+
+// AccessClaims are the claims of a first-party access token. Role is
+// empty for tokens issued before roles existed and means an ordinary user.
+// SessionID is the refresh token the access token was issued for, or
+// uuid.Nil for tokens not tied to a session.
+type AccessClaims struct {
+	UserID    uuid.UUID
+	Role      string
+	SessionID uuid.UUID
+}
+
+type accessJWTClaims struct {
+	jwt.RegisteredClaims
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+func MakeJWT(userID uuid.UUID, role, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, uuid.Nil, role, tokenSecret, expiresIn, accessTokenIssuer)
+}
+
+// MakeSessionJWT makes an access token that stops working as soon as the
+// session it belongs to is revoked.
+func MakeSessionJWT(userID, sessionID uuid.UUID, role, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, sessionID, role, tokenSecret, expiresIn, accessTokenIssuer)
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := validateJWT(tokenString, tokenSecret, accessTokenIssuer)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+func ValidateJWTClaims(tokenString, tokenSecret string) (AccessClaims, error) {
+	return validateJWT(tokenString, tokenSecret, accessTokenIssuer)
+}
+
+// Challenge tokens prove that a user passed the password step of a
+// two-factor login. They use their own issuer so they are never accepted
+// as access tokens.
+func MakeChallengeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, uuid.Nil, "", tokenSecret, expiresIn, challengeTokenIssuer)
+}
+
+func ValidateChallengeJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := validateJWT(tokenString, tokenSecret, challengeTokenIssuer)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+func makeJWT(userID, sessionID uuid.UUID, role, tokenSecret string, expiresIn time.Duration, issuer string) (string, error) {
 	timeNow := time.Now().UTC()
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(timeNow),
-		ExpiresAt: jwt.NewNumericDate(timeNow.Add(expiresIn)),
-		Subject:   userID.String(),
+	sid := ""
+	if sessionID != uuid.Nil {
+		sid = sessionID.String()
+	}
+	claims := accessJWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(timeNow),
+			ExpiresAt: jwt.NewNumericDate(timeNow.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role:      role,
+		SessionID: sid,
 	}
 	tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -23,8 +89,8 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return signedTkn, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func validateJWT(tokenString, tokenSecret, issuer string) (AccessClaims, error) {
+	claimsStruct := accessJWTClaims{}
 
 	parsedTkn, err := jwt.ParseWithClaims(
 		tokenString,
@@ -32,19 +98,28 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		func(token *jwt.Token) (interface{}, error) {
 			return []byte(tokenSecret), nil
 		},
+		jwt.WithIssuer(issuer),
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 
 	if !parsedTkn.Valid {
-		return uuid.Nil, jwt.ErrTokenInvalidClaims
+		return AccessClaims{}, jwt.ErrTokenInvalidClaims
 	}
 
 	userID, err := uuid.Parse(claimsStruct.Subject)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 
-	return userID, nil
+	claims := AccessClaims{UserID: userID, Role: claimsStruct.Role}
+	if claimsStruct.SessionID != "" {
+		claims.SessionID, err = uuid.Parse(claimsStruct.SessionID)
+		if err != nil {
+			return AccessClaims{}, err
+		}
+	}
+	return claims, nil
 }
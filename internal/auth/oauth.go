@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Access tokens issued to third-party apps carry the granted scopes and
+// use their own issuer, so ValidateJWT never treats them as first-party
+// tokens with full access.
+const oauthTokenIssuer = "chirpy-oauth"
+
+type OAuthClaims struct {
+	UserID    uuid.UUID
+	ClientID  uuid.UUID
+	SessionID uuid.UUID
+	Scopes    []string
+}
+
+func (c OAuthClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+type oauthJWTClaims struct {
+	jwt.RegisteredClaims
+	Scope     string `json:"scope"`
+	ClientID  string `json:"client_id"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// MakeOAuthJWT ties the token to the session of the refresh token issued
+// with it, so revoking the session also revokes the access token.
+func MakeOAuthJWT(userID, clientID, sessionID uuid.UUID, scope, tokenSecret string, expiresIn time.Duration) (string, error) {
+	timeNow := time.Now().UTC()
+	claims := oauthJWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    oauthTokenIssuer,
+			IssuedAt:  jwt.NewNumericDate(timeNow),
+			ExpiresAt: jwt.NewNumericDate(timeNow.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Scope:     scope,
+		ClientID:  clientID.String(),
+		SessionID: sessionID.String(),
+	}
+	tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tkn.SignedString([]byte(tokenSecret))
+}
+
+func ValidateOAuthJWT(tokenString, tokenSecret string) (OAuthClaims, error) {
+	claims := oauthJWTClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			return []byte(tokenSecret), nil
+		},
+		jwt.WithIssuer(oauthTokenIssuer),
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+	)
+	if err != nil {
+		return OAuthClaims{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return OAuthClaims{}, err
+	}
+	clientID, err := uuid.Parse(claims.ClientID)
+	if err != nil {
+		return OAuthClaims{}, err
+	}
+
+	// Tokens issued before sessions were tied to them have no sid.
+	var sessionID uuid.UUID
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return OAuthClaims{}, err
+		}
+	}
+
+	return OAuthClaims{
+		UserID:    userID,
+		ClientID:  clientID,
+		SessionID: sessionID,
+		Scopes:    strings.Fields(claims.Scope),
+	}, nil
+}
+
+func CheckPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -1,24 +1,65 @@
 package auth
 
 import (
+	"errors"
 	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
-func HashPassword(password string) (string, error) {
-	var params *argon2id.Params
-	params = argon2id.DefaultParams
+var passwordParams = argon2id.DefaultParams
+
+// SetPasswordParams changes the Argon2id parameters used for new hashes.
+// It is meant to be called once at startup, before any requests are served.
+func SetPasswordParams(params *argon2id.Params) {
+	passwordParams = params
+}
 
-	hashedPw, err := argon2id.CreateHash(password, params)
+func HashPassword(password string) (string, error) {
+	hashedPw, err := argon2id.CreateHash(password, passwordParams)
 	if err != nil {
 		return "", err
 	}
 	return hashedPw, nil
 }
 
+// CheckPasswordHash accepts Argon2id hashes and, for imported users, bcrypt
+// hashes. Callers should follow a successful check with NeedsRehash.
 func CheckPasswordHash(password, hash string) (bool, error) {
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 	check, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil {
 		return false, err
 	}
 	return check, nil
 }
+
+// NeedsRehash reports whether hash was made with another algorithm or with
+// Argon2id parameters weaker than the current ones.
+func NeedsRehash(hash string) bool {
+	params, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < passwordParams.Memory ||
+		params.Iterations < passwordParams.Iterations ||
+		params.Parallelism < passwordParams.Parallelism ||
+		params.SaltLength < passwordParams.SaltLength ||
+		params.KeyLength < passwordParams.KeyLength
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
@@ -2,13 +2,42 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 )
 
+// Refresh tokens are 64 hex characters. The first refreshSelectorLen
+// characters are stored in plaintext to look the row up; only a SHA-256
+// hash of the remainder is stored.
+const refreshSelectorLen = 16
+
 func MakeRefreshToken() (string, error) {
 	key := make([]byte, 32)
-	rand.Read(key)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
 	encoded := hex.EncodeToString(key)
 
 	return encoded, nil
 }
+
+func SplitRefreshToken(token string) (selector, verifier string, err error) {
+	if len(token) != 64 {
+		return "", "", fmt.Errorf("malformed refresh token")
+	}
+	if _, err := hex.DecodeString(token); err != nil {
+		return "", "", fmt.Errorf("malformed refresh token")
+	}
+	return token[:refreshSelectorLen], token[refreshSelectorLen:], nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func CheckTokenHash(token, tokenHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(tokenHash)) == 1
+}
@@ -0,0 +1,96 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePair(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for file, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	start := time.Now().Add(-time.Minute)
+	writePair(t, certFile, keyFile, "old.example.com", start)
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+	if reloaded, err := r.Reload(); err != nil || reloaded {
+		t.Fatalf("Expected no reload for unchanged files, got %v %v", reloaded, err)
+	}
+
+	// A key that no longer matches the certificate keeps the old pair.
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reload(); err == nil {
+		t.Fatalf("Expected an error for a broken key")
+	}
+	if got := commonName(t, r); got != "old.example.com" {
+		t.Fatalf("Expected the previous certificate to stay in use, got %q", got)
+	}
+
+	writePair(t, certFile, keyFile, "new.example.com", start.Add(30*time.Second))
+	if reloaded, err := r.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected a reload, got %v %v", reloaded, err)
+	}
+	if got := commonName(t, r); got != "new.example.com" {
+		t.Fatalf("Expected the new certificate, got %q", got)
+	}
+}
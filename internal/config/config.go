@@ -0,0 +1,527 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the server's effective configuration. Each field is named by
+// its env tag: that is the environment variable, the lower-cased name is
+// the key in a YAML or TOML config file, and the lower-cased name with
+// dashes is the command-line flag, e.g. DB_URL, db_url and -db-url.
+//
+// Sources are applied in order, later ones winning: defaults, the config
+// file, .env, the environment, then flags. .env never overrides variables
+// that are already set.
+type Config struct {
+	Port         string `env:"PORT" default:"8080"`
+	FilepathRoot string `env:"FILEPATH_ROOT" default:"."`
+	BaseURL      string `env:"BASE_URL" default:"http://localhost:8080"`
+	Platform     string `env:"PLATFORM" default:"prod"`
+	LogLevel     string `env:"LOG_LEVEL" default:"info"`
+
+	DBURL              string `env:"DB_URL" secret:"url"`
+	SecretKey          string `env:"SECRET_KEY" secret:"true"`
+	PolkaKey           string `env:"POLKA_KEY" secret:"true"`
+	PolkaSigningSecret string `env:"POLKA_SIGNING_SECRET" secret:"true"`
+	AdminKey           string `env:"ADMIN_KEY" secret:"true"`
+	MetricsToken       string `env:"METRICS_TOKEN" secret:"true"`
+
+	TracesExporter string `env:"OTEL_TRACES_EXPORTER"`
+	ServiceName    string `env:"OTEL_SERVICE_NAME" default:"chirpy"`
+
+	LockoutStore     string `env:"LOCKOUT_STORE" default:"memory"`
+	EntitlementsFile string `env:"ENTITLEMENTS_FILE"`
+
+	// OIDCProviders is a comma-separated list of provider names. Each one
+	// is configured by OIDC_<NAME>_ISSUER, _CLIENT_ID and _CLIENT_SECRET in
+	// the environment.
+	OIDCProviders string `env:"OIDC_PROVIDERS"`
+	OIDC          []OIDCProvider
+
+	AccountDeletionGrace time.Duration `env:"ACCOUNT_DELETION_GRACE" default:"720h"`
+
+	// Zero leaves the argon2id library default in place.
+	Argon2MemoryKiB   uint32 `env:"ARGON2_MEMORY_KIB"`
+	Argon2Iterations  uint32 `env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism uint8  `env:"ARGON2_PARALLELISM"`
+
+	PasswordMinLength    int     `env:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordMinEntropy   float64 `env:"PASSWORD_MIN_ENTROPY" default:"35"`
+	PasswordBreachedList string  `env:"PASSWORD_BREACHED_LIST"`
+
+	Mailer       string `env:"MAILER" default:"log"`
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     string `env:"SMTP_PORT"`
+	MailFrom     string `env:"MAIL_FROM"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true"`
+
+	HTTPReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	HTTPReadTimeout       time.Duration `env:"HTTP_READ_TIMEOUT" default:"15s"`
+	HTTPWriteTimeout      time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	HTTPIdleTimeout       time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"2m"`
+	HTTPMaxHeaderBytes    int           `env:"HTTP_MAX_HEADER_BYTES" default:"65536"`
+	HTTPMaxBodyBytes      int64         `env:"HTTP_MAX_BODY_BYTES" default:"1048576"`
+	ShutdownDrainPeriod   time.Duration `env:"SHUTDOWN_DRAIN_PERIOD" default:"10s"`
+	ShutdownTimeout       time.Duration `env:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	// Setting both TLS files serves HTTPS on TLS_PORT. PORT then only
+	// redirects to it, unless TLS_REDIRECT_HTTP is false, in which case it
+	// isn't opened at all.
+	TLSCertFile       string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `env:"TLS_KEY_FILE"`
+	TLSPort           string        `env:"TLS_PORT" default:"8443"`
+	TLSRedirectHTTP   bool          `env:"TLS_REDIRECT_HTTP" default:"true"`
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" default:"30s"`
+	// H2C serves HTTP/2 without TLS on PORT, for proxies that terminate
+	// TLS and speak HTTP/2 to the backend.
+	H2C bool `env:"H2C"`
+	// Strict-Transport-Security is sent on TLS_PORT. Browsers remember it
+	// and refuse plain HTTP until it expires, so the default is a single
+	// day; raise it once HTTPS is known to work, or set 0 to turn it off.
+	// Covering subdomains is a separate opt-in for the same reason.
+	HSTSMaxAge            time.Duration `env:"HSTS_MAX_AGE" default:"24h"`
+	HSTSIncludeSubdomains bool          `env:"HSTS_INCLUDE_SUBDOMAINS"`
+}
+
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+func (c *Config) IsDev() bool {
+	return c.Platform == "dev"
+}
+
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// SecureCookies reports whether clients reach the server over HTTPS,
+// either served here or terminated by a proxy in front of it.
+func (c *Config) SecureCookies() bool {
+	return c.TLSEnabled() || strings.HasPrefix(c.BaseURL, "https://")
+}
+
+// Load reads the configuration and validates it. It registers a flag for
+// every setting, plus -config for the file path, on fs and parses args with
+// it; callers may add flags of their own to fs beforehand.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading .env: %w", err)
+	}
+
+	cfg := &Config{}
+	fields := settings(cfg)
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	// Bool settings get bool flags, so -h2c works without a value.
+	flagValues := make(map[string]func() string, len(fields))
+	for _, f := range fields {
+		if _, ok := f.value.Interface().(bool); ok {
+			b := fs.Bool(f.flagName(), false, "overrides "+f.name)
+			flagValues[f.name] = func() string { return strconv.FormatBool(*b) }
+			continue
+		}
+		str := fs.String(f.flagName(), "", "overrides "+f.name)
+		flagValues[f.name] = func() string { return *str }
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := f.set(f.def); err != nil {
+			return nil, fmt.Errorf("default for %s: %w", f.name, err)
+		}
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		v := os.Getenv(f.name)
+		if v == "" {
+			continue
+		}
+		if err := f.set(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+		}
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flagName() == fl.Name {
+				if err := f.set(flagValues[f.name]()); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", fl.Name, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, name := range strings.Split(cfg.OIDCProviders, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg.OIDC = append(cfg.OIDC, OIDCProvider{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		})
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile applies a YAML or TOML file, picked by its extension. Unknown
+// keys are an error so typos don't go unnoticed.
+func loadFile(path string, fields []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var errs []error
+	for key, value := range values {
+		f, ok := findSetting(fields, key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, key))
+			continue
+		}
+		if err := f.set(fmt.Sprint(value)); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that required settings are present, secrets are strong
+// enough and enumerated settings hold known values. It reports every
+// problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.DBURL == "" {
+		add("DB_URL is required")
+	} else if _, err := url.Parse(c.DBURL); err != nil {
+		add("DB_URL is not a valid URL: %v", err)
+	}
+	if err := checkSecret("SECRET_KEY", c.SecretKey, 32, true); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkSecret("POLKA_KEY", c.PolkaKey, 16, true); err != nil {
+		errs = append(errs, err)
+	}
+	// Outside dev these keep Polka webhooks signed, /metrics private and
+	// the admin API reachable without a database edit.
+	for name, v := range map[string]string{
+		"POLKA_SIGNING_SECRET": c.PolkaSigningSecret,
+		"ADMIN_KEY":            c.AdminKey,
+		"METRICS_TOKEN":        c.MetricsToken,
+	} {
+		if err := checkSecret(name, v, 16, !c.IsDev()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if !validPort(c.Port) {
+		add("PORT must be a port number, got %q", c.Port)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		add("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSEnabled() {
+		if !validPort(c.TLSPort) {
+			add("TLS_PORT must be a port number, got %q", c.TLSPort)
+		} else if c.TLSRedirectHTTP && c.TLSPort == c.Port {
+			add("TLS_PORT must differ from PORT when TLS_REDIRECT_HTTP is on")
+		}
+		if c.TLSReloadInterval <= 0 {
+			add("TLS_RELOAD_INTERVAL must be positive")
+		}
+		if c.H2C {
+			add("H2C is for plain HTTP; HTTP/2 is already negotiated over TLS")
+		}
+	}
+	if c.HSTSMaxAge < 0 {
+		add("HSTS_MAX_AGE must not be negative")
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("BASE_URL must be an absolute URL, got %q", c.BaseURL)
+	}
+	if c.Platform != "dev" && c.Platform != "prod" {
+		add("PLATFORM must be dev or prod, got %q", c.Platform)
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	switch c.TracesExporter {
+	case "", "none", "otlp", "console", "stdout":
+	default:
+		add("OTEL_TRACES_EXPORTER must be otlp, console or none, got %q", c.TracesExporter)
+	}
+	if c.LockoutStore != "memory" && c.LockoutStore != "postgres" {
+		add("LOCKOUT_STORE must be memory or postgres, got %q", c.LockoutStore)
+	}
+	switch c.Mailer {
+	case "log":
+		// The log mailer prints reset and verification tokens.
+		if !c.IsDev() {
+			add("MAILER=smtp is required outside PLATFORM=dev")
+		}
+	case "smtp":
+		if c.SMTPHost == "" || c.SMTPPort == "" || c.MailFrom == "" {
+			add("MAILER=smtp needs SMTP_HOST, SMTP_PORT and MAIL_FROM")
+		}
+	default:
+		add("MAILER must be log or smtp, got %q", c.Mailer)
+	}
+	for _, p := range c.OIDC {
+		if p.Issuer == "" || p.ClientID == "" || p.ClientSecret == "" {
+			prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+			add("OIDC provider %s needs %sISSUER, %sCLIENT_ID and %sCLIENT_SECRET", p.Name, prefix, prefix, prefix)
+		}
+	}
+
+	if c.PasswordMinLength < 1 {
+		add("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if c.AccountDeletionGrace < 0 {
+		add("ACCOUNT_DELETION_GRACE must not be negative")
+	}
+	if c.HTTPMaxHeaderBytes < 1024 {
+		add("HTTP_MAX_HEADER_BYTES must be at least 1024")
+	}
+	if c.HTTPMaxBodyBytes < 1024 {
+		add("HTTP_MAX_BODY_BYTES must be at least 1024")
+	}
+	for name, d := range map[string]time.Duration{
+		"HTTP_READ_HEADER_TIMEOUT": c.HTTPReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":        c.HTTPReadTimeout,
+		"HTTP_WRITE_TIMEOUT":       c.HTTPWriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        c.HTTPIdleTimeout,
+		"SHUTDOWN_TIMEOUT":         c.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			add("%s must be positive", name)
+		}
+	}
+	if c.ShutdownDrainPeriod < 0 {
+		add("SHUTDOWN_DRAIN_PERIOD must not be negative")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+func validPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port >= 1 && port <= 65535
+}
+
+// checkSecret rejects secrets that are missing (when required), shorter
+// than minLen, or built from so few distinct characters that they are
+// easy to guess.
+func checkSecret(name, value string, minLen int, required bool) error {
+	switch {
+	case value == "" && required:
+		return fmt.Errorf("%s is required", name)
+	case value == "":
+		return nil
+	case len(value) < minLen:
+		return fmt.Errorf("%s must be at least %d characters", name, minLen)
+	}
+	distinct := map[rune]bool{}
+	for _, r := range value {
+		distinct[r] = true
+	}
+	if len(distinct) < minLen/4 {
+		return fmt.Errorf("%s is too predictable; generate one with `openssl rand -base64 48`", name)
+	}
+	return nil
+}
+
+// Dump writes the effective configuration as NAME=value lines, sorted by
+// name. Secrets are replaced with [redacted] and passwords are removed from
+// DB_URL, or all of it is redacted when it isn't a URL.
+func (c *Config) Dump(w io.Writer) error {
+	fields := settings(c)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	for _, f := range fields {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", f.name, f.redacted()); err != nil {
+			return err
+		}
+	}
+	for _, p := range c.OIDC {
+		prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+		secret := ""
+		if p.ClientSecret != "" {
+			secret = redactedValue
+		}
+		if _, err := fmt.Fprintf(w, "%sISSUER=%s\n%sCLIENT_ID=%s\n%sCLIENT_SECRET=%s\n",
+			prefix, p.Issuer, prefix, p.ClientID, prefix, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const redactedValue = "[redacted]"
+
+// setting is one tagged field of a Config.
+type setting struct {
+	name   string
+	def    string
+	secret string
+	value  reflect.Value
+}
+
+func settings(c *Config) []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var out []setting
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := sf.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		out = append(out, setting{
+			name:   name,
+			def:    sf.Tag.Get("default"),
+			secret: sf.Tag.Get("secret"),
+			value:  v.Field(i),
+		})
+	}
+	return out
+}
+
+func findSetting(fields []setting, key string) (setting, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return setting{}, false
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.name), "_", "-")
+}
+
+func (s setting) set(raw string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, s.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(n)
+	case uint8, uint32:
+		n, err := strconv.ParseUint(raw, 10, s.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.value.SetUint(n)
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+func (s setting) redacted() string {
+	raw := fmt.Sprint(s.value.Interface())
+	switch {
+	case s.secret == "" || raw == "":
+		return raw
+	case s.secret == "url":
+		// Key=value DSNs also parse as URLs, with the password in the path.
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" {
+			return redactedValue
+		}
+		// libpq also takes the password as a query parameter.
+		q := u.Query()
+		for _, key := range []string{"password", "sslpassword"} {
+			if q.Has(key) {
+				q.Set(key, "xxxxx")
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.Redacted()
+	default:
+		return redactedValue
+	}
+}
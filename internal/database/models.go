@@ -6,11 +6,33 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	Selector   string
+	KeyHash    string
+	Scopes     string
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuthEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Event     string
+	UserID    uuid.NullUUID
+	Ip        string
+	Detail    string
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,20 +41,171 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
+type DataExport struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UserID      uuid.UUID
+	Status      string
+	Archive     []byte
+	CompletedAt sql.NullTime
+	ExpiresAt   time.Time
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LoginFailure struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectUris string
+	Scopes       string
+	IsPublic     bool
+}
+
+type OauthCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
 type RefreshToken struct {
-	Token     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	Selector   string
+	TokenHash  string
+	ID         uuid.UUID
+	UserAgent  string
+	Ip         string
+	LastUsedAt time.Time
+	ClientID   uuid.NullUUID
+	Scope      string
+}
+
+type Subscription struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	Status      string
+	ExpiresAt   time.Time
+	LastEventAt sql.NullTime
+}
+
+type User struct {
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Email                string
+	PasswordHash         string
+	IsChirpyRed          bool
+	TotpSecret           sql.NullString
+	TotpEnabled          bool
+	TotpLastCounter      int64
+	EmailVerifiedAt      sql.NullTime
+	Role                 string
+	DeletionScheduledFor sql.NullTime
+	Handle               sql.NullString
+	DisplayName          string
+	Bio                  string
+	AvatarUrl            string
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Provider  string
+	Subject   string
+	Email     string
+}
+
+type UserToken struct {
+	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	UserID    uuid.UUID
+	Purpose   string
+	TokenHash string
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+	Payload   string
 }
 
-type User struct {
-	ID           uuid.UUID
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	Email        string
-	PasswordHash string
-	IsChirpyRed  bool
+type WebhookDelivery struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	EndpointID    uuid.UUID
+	EventType     string
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	DeliveredAt   sql.NullTime
+	Traceparent   string
+}
+
+type WebhookDeliveryAttempt struct {
+	ID             uuid.UUID
+	AttemptedAt    time.Time
+	DeliveryID     uuid.UUID
+	ResponseStatus int32
+	DurationMs     int32
+	Error          string
+}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	UserID              uuid.UUID
+	Url                 string
+	Secret              string
+	Events              string
+	Enabled             bool
+	ConsecutiveFailures int32
+	DisabledAt          sql.NullTime
+}
+
+type WebhookEvent struct {
+	ID            uuid.UUID
+	ReceivedAt    time.Time
+	Provider      string
+	EventID       string
+	EventType     string
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int32
+	LastError     string
+	NextAttemptAt time.Time
+	ProcessedAt   sql.NullTime
 }
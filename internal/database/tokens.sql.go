@@ -13,64 +13,232 @@ import (
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (selector, token_hash, created_at, updated_at, user_id, expires_at, user_agent, ip, last_used_at, client_id, scope)
 VALUES (
     $1,
+    $2,
     NOW(),
     NOW(),
-    $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7,
+    $8
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, selector, token_hash, id, user_agent, ip, last_used_at, client_id, scope
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
+	Selector  string
+	TokenHash string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
+	UserAgent string
+	Ip        string
+	ClientID  uuid.NullUUID
+	Scope     string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.Selector,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.Ip,
+		arg.ClientID,
+		arg.Scope,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.Selector,
+		&i.TokenHash,
+		&i.ID,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, selector, token_hash, id, user_agent, ip, last_used_at, client_id, scope
 FROM refresh_tokens
-WHERE token = $1
+WHERE selector = $1
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+func (q *Queries) GetRefreshToken(ctx context.Context, selector string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, selector)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.Selector,
+		&i.TokenHash,
+		&i.ID,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
-const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+const getSessions = `-- name: GetSessions :many
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, selector, token_hash, id, user_agent, ip, last_used_at, client_id, scope
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC
+`
+
+func (q *Queries) GetSessions(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.Selector,
+			&i.TokenHash,
+			&i.ID,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastUsedAt,
+			&i.ClientID,
+			&i.Scope,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+)
+`
+
+type IsSessionActiveParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) IsSessionActive(ctx context.Context, arg IsSessionActiveParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSessionActive, arg.ID, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const revokeAllSessions = `-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllSessions, userID)
+	return err
+}
+
+const revokeOtherSessions = `-- name: RevokeOtherSessions :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+type RevokeOtherSessionsParams struct {
+	UserID uuid.UUID
+	ID     uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherSessions, arg.UserID, arg.ID)
+	return err
+}
+
+const revokeRefreshToken = `-- name: RevokeRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE selector = $1 AND token_hash = $2 AND revoked_at IS NULL
+`
+
+type RevokeRefreshTokenParams struct {
+	Selector  string
+	TokenHash string
+}
+
+func (q *Queries) RevokeRefreshToken(ctx context.Context, arg RevokeRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshToken, arg.Selector, arg.TokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const touchRefreshToken = `-- name: TouchRefreshToken :exec
+UPDATE refresh_tokens
+SET last_used_at = NOW(), updated_at = NOW(), user_agent = $2, ip = $3
+WHERE selector = $1
+`
+
+type TouchRefreshTokenParams struct {
+	Selector  string
+	UserAgent string
+	Ip        string
+}
+
+func (q *Queries) TouchRefreshToken(ctx context.Context, arg TouchRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, touchRefreshToken, arg.Selector, arg.UserAgent, arg.Ip)
 	return err
 }
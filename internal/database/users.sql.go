@@ -7,10 +7,74 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET updated_at = NOW(), deletion_scheduled_for = NULL
+WHERE id = $1 AND deletion_scheduled_for IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const changeEmail = `-- name: ChangeEmail :one
+UPDATE users
+SET updated_at = NOW(), email = $2, email_verified_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
+`
+
+type ChangeEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) ChangeEmail(ctx context.Context, arg ChangeEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, changeEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users
+WHERE role = $1
+`
+
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, password_hash)
 VALUES (
@@ -20,7 +84,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
 `
 
 type CreateUserParams struct {
@@ -38,10 +102,61 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.PasswordHash,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
 	)
 	return i, err
 }
 
+const deletePurgedUserAuthEvents = `-- name: DeletePurgedUserAuthEvents :exec
+DELETE FROM auth_events
+WHERE user_id IN (
+    SELECT id FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+)
+OR detail IN (
+    SELECT 'account:' || LOWER(email) FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+)
+`
+
+func (q *Queries) DeletePurgedUserAuthEvents(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deletePurgedUserAuthEvents)
+	return err
+}
+
+const deletePurgedUserLoginFailures = `-- name: DeletePurgedUserLoginFailures :exec
+DELETE FROM login_failures
+WHERE key IN (
+    SELECT 'account:' || LOWER(email) FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+)
+`
+
+func (q *Queries) DeletePurgedUserLoginFailures(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deletePurgedUserLoginFailures)
+	return err
+}
+
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users
+SET updated_at = NOW(), totp_secret = NULL, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
 const downgradeChirpyRed = `-- name: DowngradeChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = FALSE
@@ -53,8 +168,59 @@ func (q *Queries) DowngradeChirpyRed(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users
+SET updated_at = NOW(), totp_enabled = TRUE
+WHERE id = $1
+`
+
+func (q *Queries) EnableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, id)
+	return err
+}
+
+const getAuthorsByIDs = `-- name: GetAuthorsByIDs :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY($1::uuid[])
+`
+
+type GetAuthorsByIDsRow struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	AvatarUrl   string
+}
+
+func (q *Queries) GetAuthorsByIDs(ctx context.Context, ids []uuid.UUID) ([]GetAuthorsByIDsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAuthorsByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAuthorsByIDsRow
+	for rows.Next() {
+		var i GetAuthorsByIDsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, password_hash, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url FROM users
 WHERE email = $1
 `
 
@@ -68,10 +234,134 @@ func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.PasswordHash,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url FROM users
+WHERE LOWER(handle) = LOWER($1::text)
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const lockUser = `-- name: LockUser :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUser, id)
+	return err
+}
+
+const promoteFirstAdmin = `-- name: PromoteFirstAdmin :one
+UPDATE users
+SET updated_at = NOW(), role = 'admin'
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
+`
+
+func (q *Queries) PromoteFirstAdmin(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, promoteFirstAdmin, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
 	)
 	return i, err
 }
 
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUsers)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const resetUsers = `-- name: ResetUsers :exec
 DELETE FROM users
 `
@@ -81,21 +371,144 @@ func (q *Queries) ResetUsers(ctx context.Context) error {
 	return err
 }
 
-const updateUser = `-- name: UpdateUser :one
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
 UPDATE users
-SET updated_at = NOW(), email = $2, password_hash = $3
+SET updated_at = NOW(), deletion_scheduled_for = $2
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
 `
 
-type UpdateUserParams struct {
+type ScheduleUserDeletionParams struct {
+	ID                   uuid.UUID
+	DeletionScheduledFor sql.NullTime
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, scheduleUserDeletion, arg.ID, arg.DeletionScheduledFor)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const setEmailVerified = `-- name: SetEmailVerified :exec
+UPDATE users
+SET updated_at = NOW(), email_verified_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) SetEmailVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, setEmailVerified, id)
+	return err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users
+SET updated_at = NOW(), totp_secret = $2, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(), role = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users
+SET updated_at = NOW(), password_hash = $2
+WHERE id = $1
+`
+
+type UpdatePasswordParams struct {
 	ID           uuid.UUID
-	Email        string
 	PasswordHash string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.PasswordHash)
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const updateProfile = `-- name: UpdateProfile :one
+UPDATE users
+SET updated_at = NOW(), handle = $2, display_name = $3, bio = $4, avatar_url = $5
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password_hash, is_chirpy_red, totp_secret, totp_enabled, totp_last_counter, email_verified_at, role, deletion_scheduled_for, handle, display_name, bio, avatar_url
+`
+
+type UpdateProfileParams struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+}
+
+func (q *Queries) UpdateProfile(ctx context.Context, arg UpdateProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -104,10 +517,39 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.PasswordHash,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastCounter,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletionScheduledFor,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
 	)
 	return i, err
 }
 
+const updateTOTPCounter = `-- name: UpdateTOTPCounter :execrows
+UPDATE users
+SET totp_last_counter = $2
+WHERE id = $1 AND totp_last_counter < $2
+`
+
+type UpdateTOTPCounterParams struct {
+	ID              uuid.UUID
+	TotpLastCounter int64
+}
+
+func (q *Queries) UpdateTOTPCounter(ctx context.Context, arg UpdateTOTPCounterParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateTOTPCounter, arg.ID, arg.TotpLastCounter)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const upgradeChirpyRed = `-- name: UpgradeChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = TRUE
@@ -0,0 +1,93 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	TierFree = "free"
+	TierRed  = "red"
+)
+
+// Limits are what a subscription tier is allowed to do. A zero
+// ChirpsPerMinute means chirps aren't rate limited on that tier.
+type Limits struct {
+	MaxChirpLength  int `json:"max_chirp_length"`
+	ChirpsPerMinute int `json:"chirps_per_minute"`
+}
+
+type Tiers map[string]Limits
+
+// Default keeps the free tier at the original 140-character chirps.
+var Default = Tiers{
+	TierFree: {
+		MaxChirpLength:  140,
+		ChirpsPerMinute: 5,
+	},
+	TierRed: {
+		MaxChirpLength:  1000,
+		ChirpsPerMinute: 30,
+	},
+}
+
+// Load reads tiers from a JSON file keyed by tier name. Tiers missing from
+// the file keep their defaults, so a file can override just one of them.
+func Load(path string) (Tiers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides Tiers
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing entitlements file: %w", err)
+	}
+
+	tiers := Tiers{}
+	for name, limits := range Default {
+		tiers[name] = limits
+	}
+	for name, limits := range overrides {
+		tiers[name] = limits
+	}
+
+	if err := tiers.validate(); err != nil {
+		return nil, err
+	}
+	return tiers, nil
+}
+
+func (t Tiers) validate() error {
+	for _, name := range []string{TierFree, TierRed} {
+		limits, ok := t[name]
+		if !ok {
+			return fmt.Errorf("entitlements: tier %q is missing", name)
+		}
+		if limits.MaxChirpLength <= 0 {
+			return fmt.Errorf("entitlements: tier %q needs a positive max_chirp_length", name)
+		}
+		if limits.ChirpsPerMinute < 0 {
+			return fmt.Errorf("entitlements: tier %q has a negative limit", name)
+		}
+	}
+	return nil
+}
+
+// TierFor maps a user's subscription state to a tier name.
+func TierFor(isChirpyRed bool) string {
+	if isChirpyRed {
+		return TierRed
+	}
+	return TierFree
+}
+
+// For returns the limits of tier, falling back to the free tier for
+// unknown names.
+func (t Tiers) For(tier string) Limits {
+	if limits, ok := t[tier]; ok {
+		return limits
+	}
+	return t[TierFree]
+}
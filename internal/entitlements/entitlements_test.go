@@ -0,0 +1,42 @@
+package entitlements
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOverridesSingleTier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiers.json")
+	content := `{"red": {"max_chirp_length": 500, "chirps_per_minute": 10}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	tiers, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading tiers: %v", err)
+	}
+
+	red := tiers.For(TierRed)
+	if red.MaxChirpLength != 500 || red.ChirpsPerMinute != 10 {
+		t.Fatalf("Red tier not overridden. Got %+v", red)
+	}
+	if tiers.For(TierFree) != Default[TierFree] {
+		t.Fatalf("Free tier should keep its defaults. Got %+v", tiers.For(TierFree))
+	}
+	if tiers.For("platinum") != tiers.For(TierFree) {
+		t.Fatalf("Unknown tiers should fall back to free")
+	}
+}
+
+func TestLoadRejectsInvalidLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiers.json")
+	if err := os.WriteFile(path, []byte(`{"free": {"max_chirp_length": 0}}`), 0o600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("Expected an error for a zero max_chirp_length, got none")
+	}
+}
@@ -0,0 +1,133 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+type State struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Store keeps failure counters by key. MemoryStore is enough for a single
+// node; PostgresStore shares the counters between instances.
+//
+// Fail records a failure at now and returns the new count, starting again
+// from one (and dropping any lock) when the previous failure is older than
+// window. It must check and update in one step so concurrent failures
+// can't reset each other's counts. Lock extends the key's lock to until and
+// never shortens it.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	Fail(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int
+	LockoutFor   time.Duration
+	Window       time.Duration
+}
+
+var AccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     5 * time.Minute,
+	LockoutAfter: 10,
+	LockoutFor:   15 * time.Minute,
+	Window:       24 * time.Hour,
+}
+
+var IPPolicy = Policy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     5 * time.Minute,
+	LockoutAfter: 100,
+	LockoutFor:   time.Hour,
+	Window:       24 * time.Hour,
+}
+
+type Result struct {
+	Failures    int
+	LockedUntil time.Time
+	LockedOut   bool
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+	Now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		policy: policy,
+		Now:    time.Now,
+	}
+}
+
+// Check returns how long the caller must wait before another attempt for
+// key is allowed. Zero means the attempt may go ahead.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	st, err := l.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	now := l.Now()
+	if st.LockedUntil.After(now) {
+		return st.LockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt and applies exponential backoff once the
+// free attempts are used up. Reaching LockoutAfter failures locks the key
+// for LockoutFor and sets LockedOut so the caller can audit it.
+func (l *Limiter) Fail(ctx context.Context, key string) (Result, error) {
+	now := l.Now()
+
+	failures, err := l.store.Fail(ctx, key, now, l.policy.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Failures: failures}
+	switch {
+	case failures == l.policy.LockoutAfter:
+		res.LockedUntil = now.Add(l.policy.LockoutFor)
+		res.LockedOut = true
+	case failures > l.policy.LockoutAfter:
+		res.LockedUntil = now.Add(l.policy.LockoutFor)
+	case failures > l.policy.FreeAttempts:
+		res.LockedUntil = now.Add(l.backoff(failures - l.policy.FreeAttempts))
+	default:
+		return res, nil
+	}
+
+	if err := l.store.Lock(ctx, key, res.LockedUntil); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+func (l *Limiter) backoff(n int) time.Duration {
+	d := l.policy.BaseDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= l.policy.MaxDelay {
+			return l.policy.MaxDelay
+		}
+	}
+	return d
+}
@@ -0,0 +1,139 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(NewMemoryStore(), Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 5,
+		LockoutFor:   time.Hour,
+		Window:       24 * time.Hour,
+	})
+	l.Now = func() time.Time { return now }
+
+	wantDelays := []time.Duration{0, 0, time.Second, 2 * time.Second}
+	for i, want := range wantDelays {
+		res, err := l.Fail(ctx, "account:a@example.com")
+		if err != nil {
+			t.Fatalf("Error recording failure: %v", err)
+		}
+		wait, err := l.Check(ctx, "account:a@example.com")
+		if err != nil {
+			t.Fatalf("Error checking key: %v", err)
+		}
+		if wait != want {
+			t.Fatalf("Wait after failure %d does not match. Got %v, want %v", i+1, wait, want)
+		}
+		if res.LockedOut {
+			t.Fatalf("Failure %d should not lock the key out", i+1)
+		}
+	}
+
+	res, err := l.Fail(ctx, "account:a@example.com")
+	if err != nil {
+		t.Fatalf("Error recording failure: %v", err)
+	}
+	if !res.LockedOut || !res.LockedUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Fifth failure should lock the key for an hour, got %+v", res)
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	wait, _ := l.Check(ctx, "account:a@example.com")
+	if wait != 0 {
+		t.Fatalf("Lockout should have expired, still waiting %v", wait)
+	}
+
+	if err := l.Reset(ctx, "account:a@example.com"); err != nil {
+		t.Fatalf("Error resetting key: %v", err)
+	}
+	res, _ = l.Fail(ctx, "account:a@example.com")
+	if res.Failures != 1 {
+		t.Fatalf("Reset should clear the counter, got %d failures", res.Failures)
+	}
+}
+
+func TestLimiterForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(NewMemoryStore(), AccountPolicy)
+	l.Now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		l.Fail(ctx, "ip:127.0.0.1")
+	}
+
+	now = now.Add(AccountPolicy.Window + time.Minute)
+	res, err := l.Fail(ctx, "ip:127.0.0.1")
+	if err != nil {
+		t.Fatalf("Error recording failure: %v", err)
+	}
+	if res.Failures != 1 {
+		t.Fatalf("Failures outside the window should be forgotten, got %d", res.Failures)
+	}
+}
+
+func TestLimiterConcurrentFailuresLock(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	l := NewLimiter(store, AccountPolicy)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	lockedOut := 0
+	for i := 0; i < AccountPolicy.LockoutAfter; i++ {
+		wg.Go(func() {
+			res, err := l.Fail(ctx, "account:a@example.com")
+			if err != nil {
+				t.Errorf("Error recording failure: %v", err)
+			}
+			if res.LockedOut {
+				mu.Lock()
+				lockedOut++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	st, _ := store.Get(ctx, "account:a@example.com")
+	if st.Failures != AccountPolicy.LockoutAfter || lockedOut != 1 {
+		t.Fatalf("Expected %d failures and one lockout, got %d and %d", AccountPolicy.LockoutAfter, st.Failures, lockedOut)
+	}
+	if wait, _ := l.Check(ctx, "account:a@example.com"); wait < AccountPolicy.LockoutFor-time.Minute {
+		t.Fatalf("Expected the key to be locked out, waiting only %v", wait)
+	}
+}
+
+func TestMemoryStoreEvictsExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	l := NewLimiter(store, IPPolicy)
+	l.Now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		l.Fail(ctx, fmt.Sprintf("ip:10.0.0.%d", i))
+	}
+	for i := 0; i < IPPolicy.LockoutAfter; i++ {
+		l.Fail(ctx, "ip:10.0.1.1")
+	}
+	if got := store.len(); got != 101 {
+		t.Fatalf("Expected 101 keys, got %d", got)
+	}
+
+	now = now.Add(IPPolicy.Window + time.Minute)
+	l.Fail(ctx, "ip:10.0.2.1")
+	if got := store.len(); got != 1 {
+		t.Fatalf("Expected expired keys to be evicted, %d left", got)
+	}
+}
@@ -0,0 +1,89 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Fail drops entries whose window and lock have
+// both run out, so a stream of new keys can't grow the map forever.
+const sweepInterval = time.Minute
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	states    map[string]memoryState
+	lastSweep time.Time
+}
+
+type memoryState struct {
+	State
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]memoryState)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key].State, nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, st := range s.states {
+			if now.After(st.expiresAt) {
+				delete(s.states, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	st := s.states[key]
+	if st.Failures > 0 && now.Sub(st.LastFailureAt) > window {
+		st = memoryState{}
+	}
+	st.Failures++
+	st.LastFailureAt = now
+	st.expiresAt = later(st.expiresAt, now.Add(window))
+	s.states[key] = st
+	return st.Failures, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.states[key]
+	if !ok {
+		return nil
+	}
+	st.LockedUntil = later(st.LockedUntil, until)
+	st.expiresAt = later(st.expiresAt, until)
+	s.states[key] = st
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+func (s *MemoryStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
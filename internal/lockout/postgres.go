@@ -0,0 +1,56 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mr_rambling/chirpy/internal/database"
+)
+
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (State, error) {
+	row, err := s.db.GetLoginFailure(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	return State{
+		Failures:      int(row.Failures),
+		LastFailureAt: row.LastFailureAt,
+		LockedUntil:   row.LockedUntil.Time,
+	}, nil
+}
+
+func (s *PostgresStore) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	row, err := s.db.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:           key,
+		LastFailureAt: now,
+		WindowStart:   now.Add(-window),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(row.Failures), nil
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.db.SetLoginLockout(ctx, database.SetLoginLockoutParams{
+		Key:         key,
+		LockedUntil: until,
+	})
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.DeleteLoginFailure(ctx, key)
+}
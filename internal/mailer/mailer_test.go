@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMessage(t *testing.T) {
+	msg := Message{
+		To:      "saul@bettercall.com",
+		Subject: "Reset your password",
+		Body:    "line one\nline two",
+	}
+
+	out, err := formatMessage("no-reply@chirpy.local", msg, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatalf("Error formatting message: %v", err)
+	}
+	raw := string(out)
+	if !strings.Contains(raw, "To: saul@bettercall.com\r\n") {
+		t.Fatalf("Message is missing the To header: %q", raw)
+	}
+	if !strings.HasSuffix(raw, "\r\n\r\nline one\r\nline two") {
+		t.Fatalf("Message body was not CRLF encoded: %q", raw)
+	}
+}
+
+func TestFormatMessageRejectsHeaderInjection(t *testing.T) {
+	for _, msg := range []Message{
+		{To: "saul@bettercall.com\r\nBcc: kim@wexlermcgill.com", Subject: "hi"},
+		{To: "saul@bettercall.com", Subject: "hi\nBcc: kim@wexlermcgill.com"},
+	} {
+		if _, err := formatMessage("no-reply@chirpy.local", msg, time.Now()); err == nil {
+			t.Errorf("Expected an error for %+v", msg)
+		}
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	err := m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi"})
+	if err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+
+	msgs := m.Messages()
+	if len(msgs) != 1 || msgs[0].To != "a@example.com" {
+		t.Fatalf("Unexpected messages: %+v", msgs)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareLabelsByPattern(t *testing.T) {
+	m := New(nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+
+	for _, path := range []string{"/api/chirps/a", "/api/chirps/b", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	m.ChirpsCreated.Inc()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		`chirpy_http_requests_total{code="404",method="GET",route="GET /api/chirps/{chirpID}"} 2`,
+		`chirpy_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`chirpy_chirps_created_total 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+	if strings.Contains(out, "/api/chirps/a") {
+		t.Errorf("Expected raw paths to stay out of the labels")
+	}
+
+	if got := m.CounterValue("chirpy_http_requests_total"); got != 3 {
+		t.Errorf("Expected 3 requests, got %v", got)
+	}
+}
@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type mockProvider struct {
+	t         *testing.T
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	now       time.Time
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	m := &mockProvider{t: t, key: key, now: time.Unix(1700000000, 0)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{
+			Issuer:                m.srv.URL,
+			AuthorizationEndpoint: m.srv.URL + "/authorize",
+			TokenEndpoint:         m.srv.URL + "/token",
+			JWKSURI:               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jwk{{
+				Kty: "RSA",
+				Kid: "test-key",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || CodeChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken: "access",
+			TokenType:   "Bearer",
+			IDToken:     m.idToken("user-123", m.nonce),
+		})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(subject, nonce string) string {
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.srv.URL,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{"chirpy"},
+			IssuedAt:  jwt.NewNumericDate(m.now),
+			ExpiresAt: jwt.NewNumericDate(m.now.Add(time.Minute)),
+		},
+		Nonce:         nonce,
+		Email:         "saul@bettercall.com",
+		EmailVerified: true,
+	}
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tkn.Header["kid"] = "test-key"
+	signed, err := tkn.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("Error signing id_token: %v", err)
+	}
+	return signed
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	m := newMockProvider(t)
+	p := NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.srv.URL,
+		ClientID:    "chirpy",
+		RedirectURL: "http://localhost:8080/api/auth/oidc/mock/callback",
+	})
+	p.Now = func() time.Time { return m.now }
+
+	state, err := NewLoginState("mock", time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating login state: %v", err)
+	}
+	m.challenge = state.CodeChallenge()
+	m.nonce = state.Nonce
+
+	authURL, err := p.AuthCodeURL(ctx, state.State, state.Nonce, state.CodeChallenge())
+	if err != nil {
+		t.Fatalf("Error building authorization URL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("code_challenge") != m.challenge || u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("Authorization URL is missing the PKCE challenge: %s", authURL)
+	}
+
+	if _, err := p.Exchange(ctx, "good-code", "wrong-verifier"); err == nil {
+		t.Fatalf("Expected error when exchanging with the wrong verifier, got none")
+	}
+
+	tr, err := p.Exchange(ctx, "good-code", state.CodeVerifier)
+	if err != nil {
+		t.Fatalf("Error exchanging code: %v", err)
+	}
+
+	idToken, err := p.VerifyIDToken(ctx, tr.IDToken, state.Nonce)
+	if err != nil {
+		t.Fatalf("Error verifying id_token: %v", err)
+	}
+	if idToken.Subject != "user-123" || !idToken.EmailVerified {
+		t.Fatalf("Unexpected id_token claims: %+v", idToken)
+	}
+
+	if _, err := p.VerifyIDToken(ctx, tr.IDToken, "other-nonce"); err == nil {
+		t.Fatalf("Expected error when the nonce does not match, got none")
+	}
+
+	p.Now = func() time.Time { return m.now.Add(time.Hour) }
+	if _, err := p.VerifyIDToken(ctx, tr.IDToken, state.Nonce); err == nil {
+		t.Fatalf("Expected error when the id_token has expired, got none")
+	}
+}
+
+func TestSealedStateTamper(t *testing.T) {
+	now := time.Now()
+	state, err := NewLoginState("mock", time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating login state: %v", err)
+	}
+
+	sealed, err := SealState(state, "secret")
+	if err != nil {
+		t.Fatalf("Error sealing state: %v", err)
+	}
+
+	opened, err := OpenState(sealed, "secret", now)
+	if err != nil {
+		t.Fatalf("Error opening state: %v", err)
+	}
+	if opened.Nonce != state.Nonce {
+		t.Fatalf("Nonce does not match. Got %v, want %v", opened.Nonce, state.Nonce)
+	}
+
+	if _, err := OpenState(sealed, "other-secret", now); err == nil {
+		t.Fatalf("Expected error when opening with the wrong secret, got none")
+	}
+	if _, err := OpenState(sealed, "secret", now.Add(time.Hour)); err == nil {
+		t.Fatalf("Expected error when opening expired state, got none")
+	}
+}
@@ -0,0 +1,97 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedList checks passwords against SHA-1 hashes in the Have I Been
+// Pwned format. Hashes are looked up by their first five hex characters,
+// so a directory of range files (one file per prefix holding SUFFIX:COUNT
+// lines) is only read one prefix at a time. A single file of full
+// HASH:COUNT lines is loaded into memory grouped the same way.
+type BreachedList struct {
+	dir      string
+	prefixes map[string]map[string]struct{}
+}
+
+const rangePrefixLen = 5
+
+func NewBreachedList(path string) (*BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachedList{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedList{prefixes: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:rangePrefixLen], hash[rangePrefixLen:]
+		if b.prefixes[prefix] == nil {
+			b.prefixes[prefix] = make(map[string]struct{})
+		}
+		b.prefixes[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading breached password list: %w", err)
+	}
+	return b, nil
+}
+
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:rangePrefixLen], hash[rangePrefixLen:]
+
+	if b.dir == "" {
+		_, ok := b.prefixes[prefix][suffix]
+		return ok, nil
+	}
+
+	f, err := openRange(b.dir, prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func openRange(dir, prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(dir, prefix))
+	}
+	return f, err
+}
@@ -0,0 +1,120 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	MinLength      int
+	MinEntropyBits float64
+	Breached       *BreachedList
+}
+
+// Check returns every rule the password breaks, so clients can show all
+// of the problems at once. A nil result means the password is acceptable.
+func (p Policy) Check(password, email string) ([]Violation, error) {
+	var violations []Violation
+
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if bits := EstimateEntropy(password); bits < p.MinEntropyBits {
+		violations = append(violations, Violation{
+			Rule:    "entropy",
+			Message: "Password is too easy to guess; use a longer mix of characters",
+		})
+	}
+
+	if email != "" && matchesEmail(password, email) {
+		violations = append(violations, Violation{
+			Rule:    "not_email",
+			Message: "Password must not be the same as your email address",
+		})
+	}
+
+	if p.Breached != nil && password != "" {
+		found, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			violations = append(violations, Violation{
+				Rule:    "breached",
+				Message: "Password has appeared in a data breach; choose a different one",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+func matchesEmail(password, email string) bool {
+	password = strings.ToLower(strings.TrimSpace(password))
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, _, _ := strings.Cut(email, "@")
+	return password == email || password == local
+}
+
+// EstimateEntropy gives a rough upper bound in bits based on the character
+// classes used. Repeated characters don't count beyond twice the number of
+// distinct ones, so "aaaaaaaaaaaa" scores far lower than its length suggests.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	distinct := make(map[rune]struct{})
+	length := 0
+	for _, r := range password {
+		length++
+		distinct[r] = struct{}{}
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	effective := length
+	if limit := 2 * len(distinct); effective > limit {
+		effective = limit
+	}
+	return float64(effective) * math.Log2(float64(pool))
+}
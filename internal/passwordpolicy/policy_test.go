@@ -0,0 +1,84 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func rules(v []Violation) []string {
+	var out []string
+	for _, x := range v {
+		out = append(out, x.Rule)
+	}
+	return out
+}
+
+func TestCheckListsEveryViolation(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "pwned.txt")
+	content := sha1Hex("saul") + ":42\n" + sha1Hex("hunter2") + ":7\n"
+	if err := os.WriteFile(list, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing list: %v", err)
+	}
+
+	breached, err := NewBreachedList(list)
+	if err != nil {
+		t.Fatalf("Error loading list: %v", err)
+	}
+	p := Policy{MinLength: 8, MinEntropyBits: 35, Breached: breached}
+
+	v, err := p.Check("saul", "saul@bettercall.com")
+	if err != nil {
+		t.Fatalf("Error checking password: %v", err)
+	}
+	got := strings.Join(rules(v), ",")
+	if got != "min_length,entropy,not_email,breached" {
+		t.Fatalf("Unexpected violations. Got %v", got)
+	}
+
+	v, err = p.Check("correct horse battery staple", "saul@bettercall.com")
+	if err != nil {
+		t.Fatalf("Error checking password: %v", err)
+	}
+	if len(v) != 0 {
+		t.Fatalf("Strong password should pass, got %v", rules(v))
+	}
+}
+
+func TestBreachedListRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("hunter2")
+	err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":7\r\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Error writing range file: %v", err)
+	}
+
+	b, err := NewBreachedList(dir)
+	if err != nil {
+		t.Fatalf("Error loading list: %v", err)
+	}
+
+	found, err := b.Contains("hunter2")
+	if err != nil || !found {
+		t.Fatalf("hunter2 should be found in the range file, got %v %v", found, err)
+	}
+	found, err = b.Contains("not in the list")
+	if err != nil || found {
+		t.Fatalf("Unknown password should not be found, got %v %v", found, err)
+	}
+}
+
+func TestEstimateEntropyPenalisesRepetition(t *testing.T) {
+	if EstimateEntropy("aaaaaaaaaaaa") >= EstimateEntropy("abcdefghijkl") {
+		t.Fatalf("Repeated characters should score lower than distinct ones")
+	}
+}
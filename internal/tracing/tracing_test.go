@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/abc", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].Name(); got != "GET /api/chirps/{chirpID}" {
+		t.Errorf("Expected span to be named after the route, got %q", got)
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("Expected span to join trace %s, got %s", traceID, got)
+	}
+	if req.Pattern != "GET /api/chirps/{chirpID}" {
+		t.Errorf("Expected the matched pattern on the outer request, got %q", req.Pattern)
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx, span := Tracer().Start(context.Background(), "emit")
+	tp := Traceparent(ctx)
+	span.End()
+	if tp == "" {
+		t.Fatalf("Expected a traceparent for a recording span")
+	}
+
+	_, child := Tracer().Start(WithTraceparent(context.Background(), tp), "deliver")
+	defer child.End()
+	if child.SpanContext().TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("Expected the delivery span to continue the emitting trace")
+	}
+
+	if Traceparent(context.Background()) != "" {
+		t.Errorf("Expected no traceparent without a span")
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	cases := map[string]string{
+		"-- name: GetUser :one\nSELECT 1": "GetUser",
+		"SELECT 1":                        "query",
+	}
+	for query, want := range cases {
+		if got := queryName(query); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
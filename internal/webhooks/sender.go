@@ -0,0 +1,157 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	HeaderEvent     = "X-Chirpy-Event"
+	HeaderDelivery  = "X-Chirpy-Delivery"
+	HeaderTimestamp = "X-Chirpy-Timestamp"
+	HeaderSignature = "X-Chirpy-Signature"
+
+	maxResponseBytes = 4 << 10
+)
+
+var ErrPrivateAddress = errors.New("webhook endpoint resolves to a private address")
+
+// Sender posts signed webhook deliveries. Unless AllowPrivate is set it
+// refuses to connect to loopback, private and link-local addresses, so
+// users can't point webhooks at services inside our network.
+type Sender struct {
+	client *http.Client
+	Now    func() time.Time
+}
+
+func NewSender(timeout time.Duration, allowPrivate bool) *Sender {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &Sender{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// A redirect could lead to an address the dialer would
+			// otherwise have to re-check; treat it as a failed delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Now: time.Now,
+	}
+}
+
+type Delivery struct {
+	ID     string
+	Event  string
+	URL    string
+	Secret string
+	Body   []byte
+}
+
+// Result describes one delivery attempt. StatusCode is zero when no
+// response was received.
+type Result struct {
+	StatusCode int
+	Duration   time.Duration
+}
+
+func (r Result) Succeeded() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Send makes a single attempt. A non-2xx response is not an error; callers
+// check Result.Succeeded. The trace context in ctx is passed on in a
+// traceparent header.
+func (s *Sender) Send(ctx context.Context, d Delivery) (result Result, err error) {
+	ctx, span := otel.Tracer("github.com/mr_rambling/chirpy/internal/webhooks").Start(ctx, "POST",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("chirpy.webhook.event", d.Event)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", result.StatusCode))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return Result{}, err
+	}
+
+	ts := s.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, auth.SignWebhook(d.Secret, ts, d.Body))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	result = Result{Duration: time.Since(start)}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	result.StatusCode = resp.StatusCode
+	if !result.Succeeded() {
+		return result, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return result, nil
+}
+
+// Backoff returns the wait before retry number attempt (starting at 1),
+// doubling from base up to max.
+func Backoff(attempt int32, base, max time.Duration) time.Duration {
+	d := base
+	for i := int32(1); i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
@@ -0,0 +1,108 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSendSignsDelivery(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"type":"chirp.created"}`)
+
+	var verifyErr error
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		verifyErr = auth.VerifyWebhookSignature(secret, r.Header.Get(HeaderTimestamp),
+			r.Header.Get(HeaderSignature), got, time.Now(), time.Minute)
+		if r.Header.Get(HeaderEvent) != "chirp.created" || r.Header.Get(HeaderDelivery) != "d1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	s := NewSender(5*time.Second, true)
+	res, err := s.Send(context.Background(), Delivery{
+		ID: "d1", Event: "chirp.created", URL: receiver.URL, Secret: secret, Body: body,
+	})
+	if err != nil {
+		t.Fatalf("Error sending delivery: %v", err)
+	}
+	if !res.Succeeded() || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected a successful delivery, got %+v", res)
+	}
+	if verifyErr != nil {
+		t.Fatalf("Receiver rejected the signature: %v", verifyErr)
+	}
+}
+
+func TestSendPropagatesTraceparent(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "deliver")
+	defer span.End()
+
+	s := NewSender(5*time.Second, true)
+	if _, err := s.Send(ctx, Delivery{ID: "d1", Event: "e", URL: receiver.URL, Body: []byte("{}")}); err != nil {
+		t.Fatalf("Error sending delivery: %v", err)
+	}
+	want := span.SpanContext().TraceID().String()
+	if len(traceparent) != 55 || traceparent[3:35] != want {
+		t.Fatalf("Expected traceparent in trace %s, got %q", want, traceparent)
+	}
+}
+
+func TestSendReportsFailure(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	s := NewSender(5*time.Second, true)
+	res, err := s.Send(context.Background(), Delivery{ID: "d1", Event: "e", URL: receiver.URL, Body: []byte("{}")})
+	if err == nil || res.Succeeded() || res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected a failed delivery, got %+v %v", res, err)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Request should not have reached the receiver")
+	}))
+	defer receiver.Close()
+
+	s := NewSender(5*time.Second, false)
+	_, err := s.Send(context.Background(), Delivery{ID: "d1", Event: "e", URL: receiver.URL, Body: []byte("{}")})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Expected ErrPrivateAddress, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	cases := map[int32]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 10: max}
+	for attempt, want := range cases {
+		if got := Backoff(attempt, base, max); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
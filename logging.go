@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestInfoKey struct{}
+
+// requestInfo travels in the request context so the access log can report
+// what handlers learn along the way, such as who made the request.
+type requestInfo struct {
+	id     string
+	userID uuid.UUID
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+func setRequestUser(ctx context.Context, userID uuid.UUID) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.userID = userID
+	}
+}
+
+// newLogger returns a JSON logger at the given level ("debug", "info",
+// "warn" or "error"). Records logged with a request context carry its
+// request ID and trace ID.
+func newLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, err
+		}
+	}
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl})
+	return slog.New(requestIDHandler{h}), nil
+}
+
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		rec.AddAttrs(slog.String("request_id", info.id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		rec.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// middlewareLogging assigns each request an ID, or keeps the caller's
+// X-Request-ID when it looks sane, echoes it in the response and writes one
+// access log line once the request is done. The route pattern the ServeMux
+// matched is copied back onto r so middleware further out can use it.
+func middlewareLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.id) {
+			info.id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, info.id)
+
+		req := r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, req)
+		r.Pattern = req.Pattern
+
+		level := slog.LevelInfo
+		if lw.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", r.Pattern),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", lw.status),
+			slog.Int64("bytes", lw.bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", clientIP(r)),
+		}
+		if info.userID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", info.userID.String()))
+		}
+		slog.LogAttrs(req.Context(), level, "request", attrs...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(c rune) bool {
+		return c < '!' || c > '~'
+	})
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	if !lw.wroteHeader {
+		lw.status = code
+		lw.wroteHeader = true
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	lw.wroteHeader = true
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return n, err
+}
+
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareLoggingRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "info")
+	if err != nil {
+		t.Fatalf("Error creating logger: %v", err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(prev)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+	})
+	handler := middlewareLogging(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/abc", nil)
+	req.Header.Set(requestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "req-123" {
+		t.Errorf("Expected request ID to be propagated, got %q", got)
+	}
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.RequestID != "req-123" {
+		t.Errorf("Expected request ID in error body, got %q (%v)", rec.Body.String(), err)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON access log line, got %q", buf.String())
+	}
+	if line["route"] != "GET /api/chirps/{chirpID}" || line["status"] != float64(404) || line["request_id"] != "req-123" {
+		t.Errorf("Unexpected access log line %v", line)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/chirps/abc", nil)
+	req.Header.Set(requestIDHeader, strings.Repeat("x", 200))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); len(got) != 36 {
+		t.Errorf("Expected an oversized request ID to be replaced, got %q", got)
+	}
+}
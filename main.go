@@ -1,24 +1,64 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/certs"
+	"github.com/mr_rambling/chirpy/internal/config"
 	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/entitlements"
+	"github.com/mr_rambling/chirpy/internal/lockout"
+	"github.com/mr_rambling/chirpy/internal/mailer"
+	"github.com/mr_rambling/chirpy/internal/metrics"
+	"github.com/mr_rambling/chirpy/internal/oidc"
+	"github.com/mr_rambling/chirpy/internal/passwordpolicy"
+	"github.com/mr_rambling/chirpy/internal/tracing"
+	"github.com/mr_rambling/chirpy/internal/webhooks"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type apiConfig struct {
-	db             *database.Queries
-	fileserverHits atomic.Int32
-	pfmUser        string
-	secretKey      string
-	polkaKey       string
+	sqlDB              *sql.DB
+	db                 *database.Queries
+	metrics            *metrics.Metrics
+	hitsBaseline       atomic.Int64
+	metricsToken       string
+	schemaVersion      int64
+	workers            *workerMonitor
+	draining           atomic.Bool
+	background         sync.WaitGroup
+	shutdown           context.Context
+	pfmUser            string
+	secretKey          string
+	polkaKey           string
+	polkaSigningSecret string
+	mailer             mailer.Mailer
+	baseURL            string
+	secureCookies      bool
+	adminKey           string
+	accountLimiter     *lockout.Limiter
+	ipLimiter          *lockout.Limiter
+	oidcProviders      map[string]*oidc.Provider
+	passwordPolicy     passwordpolicy.Policy
+	webhookSender      *webhooks.Sender
+	tiers              entitlements.Tiers
+	deletionGrace      time.Duration
+	now                func() time.Time
 }
 
 type Chirp struct {
@@ -27,52 +67,309 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	Author    *Author   `json:"author,omitempty"`
 }
 
 func main() {
-	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	platform := os.Getenv("PLATFORM")
-	secretKey := os.Getenv("SECRET_KEY")
-	polkaKey := os.Getenv("POLKA_KEY")
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	conf, err := config.Load(flags, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		conf.Dump(os.Stdout)
+		return
+	}
 
-	db, err := sql.Open("postgres", dbURL)
+	logger, err := newLogger(os.Stdout, conf.LogLevel)
+	if err != nil {
+		log.Fatalf("invalid LOG_LEVEL: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	db, err := sql.Open("postgres", conf.DBURL)
 	if err != nil {
 		log.Fatalf("error connecting to db: %v", err)
 	}
-	dbQueries := database.New(db)
+	dbQueries := database.New(tracing.WrapDB(db))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.TracesExporter, conf.ServiceName)
+	if err != nil {
+		log.Fatalf("error setting up tracing: %v", err)
+	}
 
 	apiCfg := &apiConfig{}
+	apiCfg.sqlDB = db
 	apiCfg.db = dbQueries
-	apiCfg.pfmUser = platform
-	apiCfg.secretKey = secretKey
-	apiCfg.polkaKey = polkaKey
+	apiCfg.metrics = metrics.New(db)
+	apiCfg.metricsToken = conf.MetricsToken
+	apiCfg.workers = newWorkerMonitor()
+	apiCfg.now = time.Now
+	apiCfg.schemaVersion, err = latestSchemaVersion()
+	if err != nil {
+		log.Fatalf("error reading migrations: %v", err)
+	}
+	apiCfg.pfmUser = conf.Platform
+	apiCfg.secretKey = conf.SecretKey
+	apiCfg.polkaKey = conf.PolkaKey
+	// Local development needs to deliver webhooks to receivers on localhost.
+	apiCfg.webhookSender = webhooks.NewSender(deliveryTimeout, conf.IsDev())
+	apiCfg.polkaSigningSecret = conf.PolkaSigningSecret
+	if apiCfg.polkaSigningSecret == "" {
+		slog.Warn("POLKA_SIGNING_SECRET is not set; Polka webhooks are checked by API key only")
+	}
+	apiCfg.baseURL = conf.BaseURL
+	apiCfg.secureCookies = conf.SecureCookies()
+	apiCfg.adminKey = conf.AdminKey
 
-	const filepathRoot = "."
-	const port = "8080"
+	var lockoutStore lockout.Store
+	if conf.LockoutStore == "postgres" {
+		lockoutStore = lockout.NewPostgresStore(dbQueries)
+	} else {
+		lockoutStore = lockout.NewMemoryStore()
+	}
+	apiCfg.accountLimiter = lockout.NewLimiter(lockoutStore, lockout.AccountPolicy)
+	apiCfg.ipLimiter = lockout.NewLimiter(lockoutStore, lockout.IPPolicy)
+
+	apiCfg.oidcProviders = make(map[string]*oidc.Provider)
+	for _, p := range conf.OIDC {
+		apiCfg.oidcProviders[p.Name] = oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  conf.BaseURL + "/api/auth/oidc/" + p.Name + "/callback",
+		})
+	}
+
+	apiCfg.tiers = entitlements.Default
+	if conf.EntitlementsFile != "" {
+		apiCfg.tiers, err = entitlements.Load(conf.EntitlementsFile)
+		if err != nil {
+			log.Fatalf("error loading entitlements: %v", err)
+		}
+	}
+
+	apiCfg.deletionGrace = conf.AccountDeletionGrace
+
+	argonParams := *argon2id.DefaultParams
+	if conf.Argon2MemoryKiB != 0 {
+		argonParams.Memory = conf.Argon2MemoryKiB
+	}
+	if conf.Argon2Iterations != 0 {
+		argonParams.Iterations = conf.Argon2Iterations
+	}
+	if conf.Argon2Parallelism != 0 {
+		argonParams.Parallelism = conf.Argon2Parallelism
+	}
+	auth.SetPasswordParams(&argonParams)
+
+	apiCfg.passwordPolicy = passwordpolicy.Policy{
+		MinLength:      conf.PasswordMinLength,
+		MinEntropyBits: conf.PasswordMinEntropy,
+	}
+	if conf.PasswordBreachedList != "" {
+		apiCfg.passwordPolicy.Breached, err = passwordpolicy.NewBreachedList(conf.PasswordBreachedList)
+		if err != nil {
+			log.Fatalf("error loading breached password list: %v", err)
+		}
+	}
+
+	if conf.Mailer == "smtp" {
+		apiCfg.mailer = mailer.NewSMTPMailer(conf.SMTPHost, conf.SMTPPort, conf.MailFrom, conf.SMTPUsername, conf.SMTPPassword)
+	} else {
+		apiCfg.mailer = mailer.LogMailer{}
+	}
+
+	mux := apiCfg.routes(conf.FilepathRoot)
+
+	apiCfg.workers.register(workerHousekeeping, housekeepingInterval)
+	apiCfg.workers.register(workerWebhookRetrier, webhookRetryInterval)
+	apiCfg.workers.register(workerWebhookDeliverer, deliveryPollInterval)
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	apiCfg.shutdown = workerCtx
+	var workers sync.WaitGroup
+	workers.Go(func() { apiCfg.runHousekeeping(workerCtx) })
+	workers.Go(func() { apiCfg.runWebhookRetrier(workerCtx) })
+	workers.Go(func() { apiCfg.runWebhookDeliverer(workerCtx) })
+
+	apiHandler := tracing.Middleware(middlewareLogging(apiCfg.metrics.Middleware(middlewareMaxBody(conf.HTTPMaxBodyBytes, mux))))
+	newServer := func(port string, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:              ":" + port,
+			Handler:           handler,
+			ReadHeaderTimeout: conf.HTTPReadHeaderTimeout,
+			ReadTimeout:       conf.HTTPReadTimeout,
+			WriteTimeout:      conf.HTTPWriteTimeout,
+			IdleTimeout:       conf.HTTPIdleTimeout,
+			MaxHeaderBytes:    conf.HTTPMaxHeaderBytes,
+		}
+	}
+
+	var servers []*http.Server
+	serveErr := make(chan error, 2)
+	if conf.TLSEnabled() {
+		reloader, err := certs.NewReloader(conf.TLSCertFile, conf.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("error loading TLS certificate: %v", err)
+		}
+		workers.Go(func() { reloader.Watch(workerCtx, conf.TLSReloadInterval) })
+
+		// HTTP/2 is negotiated through ALPN with the default Protocols.
+		httpsSrv := newServer(conf.TLSPort, middlewareHSTS(conf.HSTSMaxAge, conf.HSTSIncludeSubdomains, apiHandler))
+		httpsSrv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		servers = append(servers, httpsSrv)
+		go func() {
+			serveErr <- httpsSrv.ListenAndServeTLS("", "")
+		}()
+		slog.Info("serving https", "root", conf.FilepathRoot, "port", conf.TLSPort)
+
+		if conf.TLSRedirectHTTP {
+			redirectSrv := newServer(conf.Port, redirectToHTTPS(conf.TLSPort))
+			servers = append(servers, redirectSrv)
+			go func() {
+				serveErr <- redirectSrv.ListenAndServe()
+			}()
+			slog.Info("redirecting http to https", "port", conf.Port)
+		}
+	} else {
+		srv := newServer(conf.Port, apiHandler)
+		if conf.H2C {
+			srv.Protocols = new(http.Protocols)
+			srv.Protocols.SetHTTP1(true)
+			srv.Protocols.SetUnencryptedHTTP2(true)
+		}
+		servers = append(servers, srv)
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+		slog.Info("serving", "root", conf.FilepathRoot, "port", conf.Port, "h2c", conf.H2C)
+	}
+
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("error serving: %v", err)
+	case <-sigCtx.Done():
+	}
+	// A second signal kills the process straight away.
+	stopSignals()
+
+	// Fail readiness first and keep serving for a while, so load balancers
+	// stop sending new requests before the listener goes away.
+	slog.Info("shutting down", "drain_period", conf.ShutdownDrainPeriod)
+	apiCfg.draining.Store(true)
+	time.Sleep(conf.ShutdownDrainPeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	var shutdowns sync.WaitGroup
+	for _, srv := range servers {
+		shutdowns.Go(func() {
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error("shutting down server", "addr", srv.Addr, "error", err)
+			}
+		})
+	}
+	shutdowns.Wait()
+
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		apiCfg.background.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		slog.Error("background workers did not stop in time")
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("flushing traces", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("closing database", "error", err)
+	}
+	slog.Info("shutdown complete")
+}
+
+func (cfg *apiConfig) routes(filepathRoot string) *http.ServeMux {
 	handler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 
 	mux := http.NewServeMux()
-	mux.Handle("/app/", apiCfg.middlewareMetricsInc(handler))
-	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	mux.HandleFunc("GET /api/chirps/", apiCfg.handlerRetrieveChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerRetrieveChirp)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirps)
-	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
-	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", apiCfg.handlerTokenRefresh)
-	mux.HandleFunc("POST /api/revoke", apiCfg.handlerTokenRevoke)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhooks)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUserUpdate)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpDelete)
-
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
-	}
-
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	mux.Handle("/app/", cfg.middlewareMetricsInc(handler))
+	mux.HandleFunc("GET /api/healthz", handlerLivez)
+	mux.HandleFunc("GET /api/livez", handlerLivez)
+	mux.HandleFunc("GET /api/readyz", cfg.handlerReadyz)
+	mux.Handle("GET /metrics", cfg.requireMetricsToken(cfg.metrics.Handler()))
+	mux.HandleFunc("GET /admin/metrics", cfg.requirePermission(permMetricsRead, cfg.handlerMetrics))
+	mux.HandleFunc("GET /api/chirps/", cfg.handlerRetrieveChirps)
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerRetrieveChirp)
+	mux.HandleFunc("POST /admin/reset", cfg.requirePermission(permReset, cfg.handlerReset))
+	mux.HandleFunc("POST /admin/lockouts/unlock", cfg.requirePermission(permLockoutsManage, cfg.handlerAdminUnlock))
+	mux.HandleFunc("POST /admin/bootstrap", cfg.handlerAdminBootstrap)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requirePermission(permRolesManage, cfg.handlerAdminSetRole))
+	mux.HandleFunc("POST /api/chirps", cfg.handlerChirps)
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/2fa", cfg.handlerLogin2FA)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", cfg.handlerOIDCCallback)
+	mux.HandleFunc("POST /api/auth/oidc/{provider}/link", cfg.handlerOIDCLink)
+	mux.HandleFunc("POST /api/2fa/enroll", cfg.handler2FAEnroll)
+	mux.HandleFunc("POST /api/2fa/verify", cfg.handler2FAVerify)
+	mux.HandleFunc("DELETE /api/2fa", cfg.handler2FADisable)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerTokenRefresh)
+	mux.HandleFunc("POST /api/revoke", cfg.handlerTokenRevoke)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerWebhooks)
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhookEndpointsCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhookEndpointsList)
+	mux.HandleFunc("DELETE /api/webhooks/{endpointID}", cfg.handlerWebhookEndpointDelete)
+	mux.HandleFunc("POST /api/webhooks/{endpointID}/enable", cfg.handlerWebhookEndpointEnable)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries", cfg.handlerWebhookDeliveriesList)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries/{deliveryID}", cfg.handlerWebhookDeliveryGet)
+	mux.HandleFunc("GET /admin/webhooks/events", cfg.requirePermission(permWebhooksManage, cfg.handlerAdminWebhookEventsList))
+	mux.HandleFunc("GET /admin/webhooks/events/{eventID}", cfg.requirePermission(permWebhooksManage, cfg.handlerAdminWebhookEventGet))
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", cfg.requirePermission(permWebhooksManage, cfg.handlerAdminWebhookEventReplay))
+	// PUT predates PATCH and follows the same rules.
+	mux.HandleFunc("PUT /api/users", cfg.handlerUserPatch)
+	mux.HandleFunc("PATCH /api/users", cfg.handlerUserPatch)
+	mux.HandleFunc("DELETE /api/users", cfg.handlerUserDelete)
+	mux.HandleFunc("POST /api/users/confirm-deletion", cfg.handlerUserDeleteConfirm)
+	mux.HandleFunc("POST /api/users/cancel-deletion", cfg.handlerUserDeleteCancel)
+	mux.HandleFunc("GET /api/users/export", cfg.handlerUserExport)
+	mux.HandleFunc("GET /api/entitlements", cfg.handlerEntitlements)
+	mux.HandleFunc("PUT /api/users/profile", cfg.handlerProfileUpdate)
+	mux.HandleFunc("GET /api/users/{handle}", cfg.handlerProfileGet)
+	mux.HandleFunc("POST /api/users/{handle}/follow", cfg.handlerFollow)
+	mux.HandleFunc("DELETE /api/users/{handle}/follow", cfg.handlerUnfollow)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/email/verify/request", cfg.handlerEmailVerifyRequest)
+	mux.HandleFunc("POST /api/email/verify", cfg.handlerEmailVerify)
+	mux.HandleFunc("POST /api/email/change/confirm", cfg.handlerEmailChangeConfirm)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handlerChirpDelete)
+	mux.HandleFunc("GET /api/sessions", cfg.handlerSessionsList)
+	mux.HandleFunc("POST /api/keys", cfg.handlerAPIKeysCreate)
+	mux.HandleFunc("GET /api/keys", cfg.handlerAPIKeysList)
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.handlerAPIKeyRevoke)
+	mux.HandleFunc("POST /api/oauth/clients", cfg.handlerOAuthClientsCreate)
+	mux.HandleFunc("GET /api/oauth/clients", cfg.handlerOAuthClientsList)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", cfg.handlerOAuthClientDelete)
+	mux.HandleFunc("GET /oauth/authorize", cfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", cfg.handlerOAuthAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", cfg.handlerOAuthToken)
+	mux.HandleFunc("DELETE /api/sessions", cfg.handlerSessionsRevokeAll)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerSessionRevoke)
+
+	return mux
 }
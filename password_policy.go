@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/mr_rambling/chirpy/internal/passwordpolicy"
+	"net/http"
+)
+
+// checkPassword enforces the configured password policy. When the password
+// is rejected it writes a 422 listing every failed rule and returns false.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password, email string) bool {
+	violations, err := cfg.passwordPolicy.Check(password, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong checking the password", err)
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	type response struct {
+		Error      string                     `json:"error"`
+		Violations []passwordpolicy.Violation `json:"violations"`
+	}
+	respondWithJSON(w, http.StatusUnprocessableEntity, response{
+		Error:      "Password does not meet the password policy",
+		Violations: violations,
+	})
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"net/http"
+	"slices"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+type permission string
+
+const (
+	permMetricsRead    permission = "metrics:read"
+	permReset          permission = "admin:reset"
+	permLockoutsManage permission = "lockouts:manage"
+	permRolesManage    permission = "roles:manage"
+	permChirpsModerate permission = "chirps:moderate"
+	permWebhooksManage permission = "webhooks:manage"
+)
+
+var rolePermissions = map[string][]permission{
+	roleUser:      nil,
+	roleModerator: {permChirpsModerate},
+	roleAdmin: {
+		permMetricsRead,
+		permReset,
+		permLockoutsManage,
+		permRolesManage,
+		permChirpsModerate,
+		permWebhooksManage,
+	},
+}
+
+var errPermissionDenied = errors.New("role does not grant the required permission")
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func roleHasPermission(role string, perm permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// authorize checks that the request carries a first-party access token
+// for a user whose role grants perm. The role is read from the database
+// rather than the token, so a demotion takes effect at once.
+func (cfg *apiConfig) authorize(r *http.Request, perm permission) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, errAuthMissing
+	}
+
+	claims, err := auth.ValidateJWTClaims(token, cfg.secretKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := cfg.checkSession(r.Context(), claims.UserID, claims.SessionID); err != nil {
+		return uuid.Nil, err
+	}
+	setRequestUser(r.Context(), claims.UserID)
+	dbUser, err := cfg.db.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !roleHasPermission(dbUser.Role, perm) {
+		return uuid.Nil, errPermissionDenied
+	}
+	return claims.UserID, nil
+}
+
+func (cfg *apiConfig) requirePermission(perm permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := cfg.authorize(r, perm); err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
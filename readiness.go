@@ -1,11 +1,177 @@
 package main
 
 import (
+	"context"
+	"embed"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func handlerReadiness(w http.ResponseWriter, r *http.Request) {
+const (
+	readinessTimeout = 2 * time.Second
+
+	// A worker is reported stale once it has missed this many runs.
+	workerStaleRuns = 3
+
+	workerHousekeeping     = "housekeeping"
+	workerWebhookRetrier   = "webhook_retrier"
+	workerWebhookDeliverer = "webhook_deliverer"
+)
+
+//go:embed sql/schema/*.sql
+var schemaFS embed.FS
+
+// handlerLivez only says the process is up and serving requests; it checks
+// no dependencies, so a database outage doesn't get the server restarted.
+func handlerLivez(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
+
+type checkResult struct {
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Version  int64      `json:"version,omitempty"`
+	Expected int64      `json:"expected,omitempty"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+}
+
+// handlerReadyz reports whether this instance should receive traffic. The
+// database, its schema version and draining decide the answer; background
+// workers are reported too, but a stuck worker doesn't take the API out of
+// rotation since removing traffic wouldn't fix it.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]checkResult{}
+	ready := true
+
+	if cfg.draining.Load() {
+		checks["draining"] = checkResult{Status: "fail", Error: "server is shutting down"}
+		ready = false
+	}
+
+	// The endpoint is unauthenticated, so driver errors are only logged.
+	if err := cfg.sqlDB.PingContext(ctx); err != nil {
+		slog.WarnContext(ctx, "readiness: pinging the database", "error", err)
+		checks["database"] = checkResult{Status: "fail", Error: "database is unreachable"}
+		ready = false
+	} else {
+		checks["database"] = checkResult{Status: "ok"}
+	}
+
+	migrations := checkResult{Status: "ok", Expected: cfg.schemaVersion}
+	var version int64
+	err := cfg.sqlDB.QueryRowContext(ctx,
+		"SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1").Scan(&version)
+	migrations.Version = version
+	if err != nil {
+		slog.WarnContext(ctx, "readiness: reading the schema version", "error", err)
+		migrations.Status = "fail"
+		migrations.Error = "schema version is unavailable"
+		ready = false
+	} else if version < cfg.schemaVersion {
+		migrations.Status = "fail"
+		migrations.Error = "database schema is behind; run the migrations"
+		ready = false
+	}
+	checks["migrations"] = migrations
+
+	for name, check := range cfg.workers.check(time.Now()) {
+		checks["worker:"+name] = check
+	}
+
+	type response struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if !ready {
+		respondWithJSON(w, http.StatusServiceUnavailable, response{Status: "unavailable", Checks: checks})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Status: "ok", Checks: checks})
+}
+
+// latestSchemaVersion returns the number of the newest goose migration,
+// which is the version this build expects the database to be at.
+func latestSchemaVersion() (int64, error) {
+	entries, err := schemaFS.ReadDir("sql/schema")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(path.Base(e.Name()), "_")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		latest = max(latest, v)
+	}
+	return latest, nil
+}
+
+// workerMonitor tracks when each background worker last finished a run.
+type workerMonitor struct {
+	mu      sync.Mutex
+	workers map[string]*workerState
+}
+
+type workerState struct {
+	interval time.Duration
+	lastRun  time.Time
+	lastErr  error
+}
+
+func newWorkerMonitor() *workerMonitor {
+	return &workerMonitor{workers: make(map[string]*workerState)}
+}
+
+// register starts tracking a worker that runs every interval. It counts as
+// healthy until it has had time to miss a few runs.
+func (m *workerMonitor) register(name string, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[name] = &workerState{interval: interval, lastRun: time.Now()}
+}
+
+// beat records a finished run. err is the run's failure, if any.
+func (m *workerMonitor) beat(name string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.workers[name]; ok {
+		w.lastRun = time.Now()
+		w.lastErr = err
+	}
+}
+
+func (m *workerMonitor) check(now time.Time) map[string]checkResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	checks := make(map[string]checkResult, len(m.workers))
+	for name, w := range m.workers {
+		lastRun := w.lastRun
+		c := checkResult{Status: "ok", LastRun: &lastRun}
+		switch {
+		case now.Sub(w.lastRun) > workerStaleRuns*w.interval:
+			c.Status = "stale"
+		case w.lastErr != nil:
+			// The worker logs the error itself.
+			c.Status = "degraded"
+			c.Error = "last run failed"
+		}
+		checks[name] = c
+	}
+	return checks
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerMonitor(t *testing.T) {
+	m := newWorkerMonitor()
+	m.register("fast", time.Second)
+	m.register("failing", time.Minute)
+	m.beat("failing", errors.New("db down"))
+
+	checks := m.check(time.Now().Add(10 * time.Second))
+	if got := checks["fast"].Status; got != "stale" {
+		t.Errorf("Expected a worker that missed its runs to be stale, got %q", got)
+	}
+	if got := checks["failing"]; got.Status != "degraded" || got.Error != "last run failed" {
+		t.Errorf("Expected a failing worker to be degraded, got %+v", got)
+	}
+
+	m.beat("failing", nil)
+	if got := m.check(time.Now())["failing"].Status; got != "ok" {
+		t.Errorf("Expected the worker to recover, got %q", got)
+	}
+}
+
+func TestLatestSchemaVersion(t *testing.T) {
+	v, err := latestSchemaVersion()
+	if err != nil {
+		t.Fatalf("Error reading migrations: %v", err)
+	}
+	if v < 21 {
+		t.Errorf("Expected the newest migration to be at least 21, got %d", v)
+	}
+}
+
+func TestReadyzHidesDriverErrors(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	mock.ExpectQuery("goose_db_version").
+		WillReturnError(errors.New(`pq: password authentication failed for user "chirpy"`))
+
+	rec := httptest.NewRecorder()
+	cfg.handlerReadyz(rec, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the instance to be unready, got %d %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "chirpy") || !strings.Contains(body, "schema version is unavailable") {
+		t.Fatalf("Expected a generic error, got %s", body)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const backgroundTimeout = 5 * time.Minute
+
+// middlewareMaxBody caps request bodies at limit bytes. Requests that
+// declare a larger Content-Length are refused up front; chunked bodies are
+// cut off by http.MaxBytesReader, which makes the JSON decode fail.
+func middlewareMaxBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", limit), nil)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runInBackground runs fn after the handler has returned, for work such as
+// sending mail whose duration shouldn't show in the response time. fn keeps
+// the request's values for logging but not its cancellation. Shutdown
+// cancels fn's context and waits for it like it does for the workers.
+func (cfg *apiConfig) runInBackground(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	cfg.background.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, backgroundTimeout)
+		defer cancel()
+		if cfg.shutdown != nil {
+			stop := context.AfterFunc(cfg.shutdown, cancel)
+			defer stop()
+		}
+		if err := fn(ctx); err != nil {
+			slog.ErrorContext(ctx, name, "error", err)
+		}
+	})
+}
+
+// middlewareHSTS tells browsers to stick to HTTPS. It only answers
+// requests that came in over TLS, as the header is ignored on plain HTTP.
+func middlewareHSTS(maxAge time.Duration, includeSubDomains bool, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS sends every request to the same host and path on the
+// HTTPS listener. 308 keeps the method and body for API clients.
+func redirectToHTTPS(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if tlsPort == "443" {
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		} else {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareMaxBody(t *testing.T) {
+	handler := middlewareMaxBody(16, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"small", `{"a":"b"}`, false, http.StatusNoContent},
+		{"declared too large", `{"a":"` + strings.Repeat("x", 32) + `"}`, false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", `{"a":"` + strings.Repeat("x", 32) + `"}`, true, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(c.body))
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.want, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareHSTS(t *testing.T) {
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := middlewareHSTS(time.Hour, false, noop)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/healthz", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS over plain HTTP, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "https://localhost/api/healthz", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=3600" {
+		t.Errorf("Expected HSTS over TLS, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	middlewareHSTS(time.Hour, true, noop).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=3600; includeSubDomains" {
+		t.Errorf("Expected subdomains to be covered when asked, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	middlewareHSTS(0, true, noop).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS with a zero max age, got %q", got)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	cases := []struct {
+		host    string
+		tlsPort string
+		want    string
+	}{
+		{"chirpy.example.com:8080", "8443", "https://chirpy.example.com:8443/api/chirps?sort=desc"},
+		{"chirpy.example.com", "443", "https://chirpy.example.com/api/chirps?sort=desc"},
+		{"[::1]:8080", "443", "https://[::1]/api/chirps?sort=desc"},
+		{"[::1]", "8443", "https://[::1]:8443/api/chirps?sort=desc"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps?sort=desc", nil)
+		req.Host = c.host
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(c.tlsPort).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != c.want {
+			t.Errorf("%s: expected 308 to %s, got %d %s", c.host, c.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
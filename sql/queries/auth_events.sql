@@ -0,0 +1,10 @@
+-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (id, created_at, event, user_id, ip, detail)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
\ No newline at end of file
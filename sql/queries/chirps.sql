@@ -19,4 +19,17 @@ WHERE id = $1;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetChirpsByUser :many
+SELECT * FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: CountChirpsByUser :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1;
+
+-- name: CountChirpsByUserSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND created_at >= $2;
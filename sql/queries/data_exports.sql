@@ -0,0 +1,29 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, user_id, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: GetLatestDataExport :one
+SELECT * FROM data_exports
+WHERE user_id = $1 AND expires_at > NOW()
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', archive = $2, completed_at = NOW()
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteExpiredDataExports :exec
+DELETE FROM data_exports
+WHERE expires_at <= NOW();
@@ -0,0 +1,24 @@
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: CountFollowers :one
+SELECT COUNT(*) FROM follows
+WHERE followee_id = $1;
+
+-- name: CountFollowing :one
+SELECT COUNT(*) FROM follows
+WHERE follower_id = $1;
+
+-- name: GetFollowing :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id IN (SELECT followee_id FROM follows WHERE follower_id = $1);
+
+-- name: GetFollowers :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id IN (SELECT follower_id FROM follows WHERE followee_id = $1);
\ No newline at end of file
@@ -0,0 +1,35 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures
+WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures (key, failures, last_failure_at)
+VALUES (
+    @key,
+    1,
+    @last_failure_at
+)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < @window_start THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    locked_until = CASE
+        WHEN login_failures.last_failure_at < @window_start THEN NULL
+        ELSE login_failures.locked_until
+    END,
+    last_failure_at = EXCLUDED.last_failure_at
+RETURNING *;
+
+-- name: SetLoginLockout :exec
+UPDATE login_failures
+SET locked_until = GREATEST(locked_until, @locked_until::timestamp)
+WHERE key = @key;
+
+-- name: DeleteExpiredLoginFailures :execrows
+DELETE FROM login_failures
+WHERE last_failure_at < $1;
+
+-- name: DeleteLoginFailure :exec
+DELETE FROM login_failures
+WHERE key = $1;
\ No newline at end of file
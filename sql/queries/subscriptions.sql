@@ -0,0 +1,36 @@
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, expires_at, last_event_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), status = EXCLUDED.status, expires_at = EXCLUDED.expires_at, last_event_at = EXCLUDED.last_event_at
+RETURNING *;
+
+-- name: GetSubscriptionForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: SetSubscriptionStatus :execrows
+UPDATE subscriptions
+SET updated_at = NOW(), status = $2, last_event_at = $3
+WHERE user_id = $1;
+
+-- name: ExpireSubscriptions :execrows
+WITH expired AS (
+    UPDATE subscriptions
+    SET updated_at = NOW(), status = 'expired'
+    WHERE status = 'active' AND expires_at <= NOW()
+    RETURNING user_id
+)
+UPDATE users
+SET is_chirpy_red = FALSE
+FROM expired
+WHERE users.id = expired.user_id;
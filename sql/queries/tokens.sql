@@ -1,20 +1,58 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (selector, token_hash, created_at, updated_at, user_id, expires_at, user_agent, ip, last_used_at, client_id, scope)
 VALUES (
     $1,
+    $2,
     NOW(),
     NOW(),
-    $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7,
+    $8
 )
 RETURNING *;
 
 -- name: GetRefreshToken :one
 SELECT *
 FROM refresh_tokens
-WHERE token = $1;
+WHERE selector = $1;
+
+-- name: RevokeRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE selector = $1 AND token_hash = $2 AND revoked_at IS NULL;
+
+-- name: TouchRefreshToken :exec
+UPDATE refresh_tokens
+SET last_used_at = NOW(), updated_at = NOW(), user_agent = $2, ip = $3
+WHERE selector = $1;
+
+-- name: GetSessions :many
+SELECT *
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+);
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
 
--- name: RevokeRefreshToken :exec
+-- name: RevokeOtherSessions :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL;
\ No newline at end of file
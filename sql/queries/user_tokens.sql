@@ -0,0 +1,26 @@
+-- name: CreateUserToken :one
+INSERT INTO user_tokens (id, created_at, user_id, purpose, token_hash, expires_at, payload)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: ConsumeUserToken :one
+UPDATE user_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteUnusedUserTokens :exec
+DELETE FROM user_tokens
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;
+
+-- name: GetUserToken :one
+SELECT * FROM user_tokens
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW();
@@ -16,12 +16,6 @@ DELETE FROM users;
 SELECT * FROM users
 WHERE email = $1;
 
--- name: UpdateUser :one
-UPDATE users
-SET updated_at = NOW(), email = $2, password_hash = $3
-WHERE id = $1
-RETURNING *;
-
 -- name: UpgradeChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = TRUE
@@ -30,4 +24,113 @@ WHERE id = $1;
 -- name: DowngradeChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = FALSE
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: LockUser :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
+
+-- name: SetTOTPSecret :exec
+UPDATE users
+SET updated_at = NOW(), totp_secret = $2, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET updated_at = NOW(), totp_enabled = TRUE
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET updated_at = NOW(), totp_secret = NULL, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $1;
+
+-- name: UpdateTOTPCounter :execrows
+UPDATE users
+SET totp_last_counter = $2
+WHERE id = $1 AND totp_last_counter < $2;
+
+-- name: SetEmailVerified :exec
+UPDATE users
+SET updated_at = NOW(), email_verified_at = NOW()
+WHERE id = $1;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET updated_at = NOW(), password_hash = $2
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(), role = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: PromoteFirstAdmin :one
+UPDATE users
+SET updated_at = NOW(), role = 'admin'
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users
+WHERE role = $1;
+
+-- name: ScheduleUserDeletion :one
+UPDATE users
+SET updated_at = NOW(), deletion_scheduled_for = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET updated_at = NOW(), deletion_scheduled_for = NULL
+WHERE id = $1 AND deletion_scheduled_for IS NOT NULL;
+
+-- name: DeletePurgedUserAuthEvents :exec
+DELETE FROM auth_events
+WHERE user_id IN (
+    SELECT id FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+)
+OR detail IN (
+    SELECT 'account:' || LOWER(email) FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+);
+
+-- name: DeletePurgedUserLoginFailures :exec
+DELETE FROM login_failures
+WHERE key IN (
+    SELECT 'account:' || LOWER(email) FROM users
+    WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW()
+);
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deletion_scheduled_for IS NOT NULL AND deletion_scheduled_for <= NOW();
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE LOWER(handle) = LOWER(sqlc.arg(handle)::text);
+
+-- name: UpdateProfile :one
+UPDATE users
+SET updated_at = NOW(), handle = $2, display_name = $3, bio = $4, avatar_url = $5
+WHERE id = $1
+RETURNING *;
+
+-- name: GetAuthorsByIDs :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY(@ids::uuid[]);
+
+-- name: ChangeEmail :one
+UPDATE users
+SET updated_at = NOW(), email = $2, email_verified_at = NOW()
+WHERE id = $1
+RETURNING *;
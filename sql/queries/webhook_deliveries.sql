@@ -0,0 +1,69 @@
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (id, created_at, endpoint_id, event_type, payload, next_attempt_at, traceparent)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+)
+RETURNING *;
+
+-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = NOW() + INTERVAL '5 minutes'
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkWebhookDeliverySucceeded :exec
+UPDATE webhook_deliveries
+SET status = 'succeeded', attempts = attempts + 1, delivered_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookDeliveryRetry :exec
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, next_attempt_at = $2
+WHERE id = $1;
+
+-- name: MarkWebhookDeliveryFailed :exec
+UPDATE webhook_deliveries
+SET status = 'failed', attempts = attempts + 1
+WHERE id = $1;
+
+-- name: GetWebhookDeliveriesByEndpoint :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, attempted_at, delivery_id, response_status, duration_ms, error)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: DeleteOldWebhookDeliveryAttempts :execrows
+DELETE FROM webhook_delivery_attempts
+WHERE attempted_at < $1;
+
+-- name: GetWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE delivery_id = $1
+ORDER BY attempted_at ASC;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1;
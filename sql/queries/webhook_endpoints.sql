@@ -0,0 +1,54 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, user_id, url, secret, events)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: GetWebhookEndpointsByUser :many
+SELECT * FROM webhook_endpoints
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: CountWebhookEndpointsByUser :one
+SELECT COUNT(*) FROM webhook_endpoints
+WHERE user_id = $1;
+
+-- name: GetEnabledWebhookEndpointsByUser :many
+SELECT * FROM webhook_endpoints
+WHERE user_id = $1 AND enabled = TRUE;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1 AND user_id = $2;
+
+-- name: EnableWebhookEndpoint :execrows
+UPDATE webhook_endpoints
+SET updated_at = NOW(), enabled = TRUE, consecutive_failures = 0, disabled_at = NULL
+WHERE id = $1 AND user_id = $2;
+
+-- name: RecordWebhookEndpointSuccess :exec
+UPDATE webhook_endpoints
+SET consecutive_failures = 0
+WHERE id = $1;
+
+-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints
+SET consecutive_failures = consecutive_failures + 1
+WHERE id = $1
+RETURNING consecutive_failures;
+
+-- name: DisableWebhookEndpoint :exec
+UPDATE webhook_endpoints
+SET updated_at = NOW(), enabled = FALSE, disabled_at = NOW()
+WHERE id = $1;
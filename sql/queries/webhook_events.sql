@@ -0,0 +1,55 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, received_at, provider, event_id, event_type, payload, next_attempt_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: LockWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1 AND status <> 'processed'
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET status = 'processed', processed_at = NOW(), attempts = attempts + 1, last_error = ''
+WHERE id = $1;
+
+-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events
+SET status = 'failed', attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+WHERE id = $1;
+
+-- name: GetDueWebhookEvents :many
+SELECT id FROM webhook_events
+WHERE status IN ('pending', 'failed') AND attempts < $1 AND next_attempt_at <= NOW()
+ORDER BY next_attempt_at ASC
+LIMIT $2;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE status = $1
+ORDER BY received_at DESC
+LIMIT $2;
+
+-- name: ListAllWebhookEvents :many
+SELECT * FROM webhook_events
+ORDER BY received_at DESC
+LIMIT $1;
+
+-- name: ResetWebhookEvent :one
+UPDATE webhook_events
+SET status = 'pending', attempts = 0, last_error = '', next_attempt_at = NOW(), processed_at = NULL
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN selector TEXT,
+ADD COLUMN token_hash TEXT;
+
+UPDATE refresh_tokens
+SET selector = substr(token, 1, 16),
+    token_hash = encode(sha256(convert_to(substr(token, 17), 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens
+DROP COLUMN token;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN selector SET NOT NULL,
+ALTER COLUMN token_hash SET NOT NULL;
+
+ALTER TABLE refresh_tokens
+ADD PRIMARY KEY (selector);
+
+-- +goose Down
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN selector,
+DROP COLUMN token_hash;
+
+ALTER TABLE refresh_tokens
+ADD COLUMN token TEXT PRIMARY KEY;
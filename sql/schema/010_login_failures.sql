@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+CREATE TABLE auth_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    event TEXT NOT NULL,
+    user_id UUID,
+    ip TEXT NOT NULL,
+    detail TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- +goose Down
+DROP TABLE auth_events;
+DROP TABLE login_failures;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN deletion_scheduled_for TIMESTAMP;
+
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    archive BYTEA,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE data_exports;
+
+ALTER TABLE users
+DROP COLUMN deletion_scheduled_for;
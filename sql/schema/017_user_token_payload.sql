@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE user_tokens
+ADD COLUMN payload TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE user_tokens
+DROP COLUMN payload;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL UNIQUE,
+    status TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Existing Chirpy Red users predate the table and never had an expiry.
+-- Give them one default period (defaultSubscriptionPeriod); Polka's next
+-- renewal sets the real one.
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, expires_at)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'active', NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    -- JSON rather than JSONB keeps the body byte for byte.
+    payload JSON NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX webhook_events_due_idx ON webhook_events (next_attempt_at)
+WHERE status IN ('pending', 'failed');
+
+-- Subscriptions remember the newest event applied to them, so an older
+-- event that is retried or replayed later can't undo it.
+ALTER TABLE subscriptions
+ADD COLUMN last_event_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE subscriptions
+DROP COLUMN last_event_at;
+
+DROP TABLE webhook_events;
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/tracing"
+	"github.com/mr_rambling/chirpy/internal/webhooks"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	deliveryPollInterval = 5 * time.Second
+	deliveryBatch        = 20
+	deliveryTimeout      = 10 * time.Second
+	deliveryMaxAttempts  = 8
+	deliveryBaseBackoff  = 30 * time.Second
+	deliveryMaxBackoff   = 6 * time.Hour
+
+	// An endpoint is disabled after this many failed attempts in a row,
+	// across all of its deliveries.
+	endpointFailureLimit = 15
+
+	// Attempt logs are kept this long for debugging, then dropped by
+	// housekeeping.
+	webhookAttemptRetention = 30 * 24 * time.Hour
+)
+
+type webhookPayload struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// emitWebhookEvent queues a delivery of event to each of the user's enabled
+// endpoints that subscribed to it. Failures are logged; they never fail the
+// request that triggered the event.
+func (cfg *apiConfig) emitWebhookEvent(ctx context.Context, userID uuid.UUID, event string, data interface{}) {
+	endpoints, err := cfg.db.GetEnabledWebhookEndpointsByUser(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading webhook endpoints", "event", event, "error", err)
+		return
+	}
+
+	var body []byte
+	for _, endpoint := range endpoints {
+		if !slices.Contains(strings.Fields(endpoint.Events), event) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(webhookPayload{
+				ID:        uuid.New(),
+				Type:      event,
+				CreatedAt: time.Now().UTC(),
+				Data:      data,
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "encoding webhook payload", "event", event, "error", err)
+				return
+			}
+		}
+
+		_, err = cfg.db.CreateWebhookDelivery(ctx, database.CreateWebhookDeliveryParams{
+			EndpointID:  endpoint.ID,
+			EventType:   event,
+			Payload:     body,
+			Traceparent: tracing.Traceparent(ctx),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "queueing webhook delivery", "endpoint_id", endpoint.ID, "error", err)
+		}
+	}
+}
+
+// runWebhookDeliverer sends queued deliveries. Claiming a batch pushes its
+// next_attempt_at forward, so a delivery whose worker dies mid-attempt is
+// picked up again later rather than lost.
+func (cfg *apiConfig) runWebhookDeliverer(ctx context.Context) {
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deliveries, err := cfg.db.ClaimDueWebhookDeliveries(ctx, deliveryBatch)
+		cfg.workers.beat(workerWebhookDeliverer, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "claiming webhook deliveries", "error", err)
+			continue
+		}
+		// A full batch of slow receivers takes longer than the stale
+		// threshold, so each delivery counts as a sign of life.
+		for _, d := range deliveries {
+			cfg.attemptWebhookDelivery(ctx, d)
+			cfg.workers.beat(workerWebhookDeliverer, nil)
+		}
+	}
+}
+
+// attemptWebhookDelivery runs in the trace of the request that queued the
+// delivery, so the receiver sees the same trace ID.
+func (cfg *apiConfig) attemptWebhookDelivery(ctx context.Context, d database.WebhookDelivery) {
+	ctx, span := tracing.Tracer().Start(tracing.WithTraceparent(ctx, d.Traceparent), "webhook deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("chirpy.webhook.event", d.EventType),
+			attribute.String("chirpy.webhook.delivery_id", d.ID.String()),
+		),
+	)
+	defer span.End()
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(ctx, d.EndpointID)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading webhook endpoint", "endpoint_id", d.EndpointID, "error", err)
+		return
+	}
+	if !endpoint.Enabled {
+		if err := cfg.db.MarkWebhookDeliveryFailed(ctx, d.ID); err != nil {
+			slog.ErrorContext(ctx, "failing webhook delivery", "delivery_id", d.ID, "error", err)
+		}
+		return
+	}
+
+	res, sendErr := cfg.webhookSender.Send(ctx, webhooks.Delivery{
+		ID:     d.ID.String(),
+		Event:  d.EventType,
+		URL:    endpoint.Url,
+		Secret: endpoint.Secret,
+		Body:   d.Payload,
+	})
+
+	errMsg := ""
+	if sendErr != nil {
+		errMsg = sendErr.Error()
+	}
+	err = cfg.db.CreateWebhookDeliveryAttempt(ctx, database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID:     d.ID,
+		ResponseStatus: int32(res.StatusCode),
+		DurationMs:     int32(res.Duration.Milliseconds()),
+		Error:          errMsg,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "recording webhook delivery attempt", "delivery_id", d.ID, "error", err)
+	}
+
+	if sendErr == nil {
+		if err := cfg.db.MarkWebhookDeliverySucceeded(ctx, d.ID); err != nil {
+			slog.ErrorContext(ctx, "completing webhook delivery", "delivery_id", d.ID, "error", err)
+		}
+		if err := cfg.db.RecordWebhookEndpointSuccess(ctx, endpoint.ID); err != nil {
+			slog.ErrorContext(ctx, "resetting webhook endpoint failures", "endpoint_id", endpoint.ID, "error", err)
+		}
+		return
+	}
+
+	if d.Attempts+1 >= deliveryMaxAttempts {
+		err = cfg.db.MarkWebhookDeliveryFailed(ctx, d.ID)
+	} else {
+		err = cfg.db.MarkWebhookDeliveryRetry(ctx, database.MarkWebhookDeliveryRetryParams{
+			ID:            d.ID,
+			NextAttemptAt: time.Now().UTC().Add(webhooks.Backoff(d.Attempts+1, deliveryBaseBackoff, deliveryMaxBackoff)),
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "rescheduling webhook delivery", "delivery_id", d.ID, "error", err)
+	}
+
+	failures, err := cfg.db.RecordWebhookEndpointFailure(ctx, endpoint.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "recording webhook endpoint failure", "endpoint_id", endpoint.ID, "error", err)
+		return
+	}
+	if failures >= endpointFailureLimit {
+		slog.WarnContext(ctx, "disabling webhook endpoint after consecutive failures", "endpoint_id", endpoint.ID, "failures", failures)
+		if err := cfg.db.DisableWebhookEndpoint(ctx, endpoint.ID); err != nil {
+			slog.ErrorContext(ctx, "disabling webhook endpoint", "endpoint_id", endpoint.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/auth"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttemptWebhookDeliveryDisablesFailingEndpoint(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	cfg, mock := newTestConfig(t)
+	cfg.webhookSender = webhooks.NewSender(time.Second, true)
+	endpoint := database.WebhookEndpoint{ID: uuid.New(), UserID: uuid.New(), Url: srv.URL, Secret: "whsec_test", Events: eventChirpCreated, Enabled: true}
+	delivery := database.WebhookDelivery{ID: uuid.New(), EndpointID: endpoint.ID, EventType: eventChirpCreated, Payload: []byte(`{}`)}
+
+	for failures := endpointFailureLimit - 1; failures <= endpointFailureLimit; failures++ {
+		expectQuery(mock, "GetWebhookEndpoint").WithArgs(endpoint.ID).WillReturnRows(modelRows(endpoint))
+		expectExec(mock, "CreateWebhookDeliveryAttempt").WithArgs(delivery.ID, int32(http.StatusInternalServerError), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectExec(mock, "MarkWebhookDeliveryRetry").WithArgs(delivery.ID, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectQuery(mock, "RecordWebhookEndpointFailure").WithArgs(endpoint.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"consecutive_failures"}).AddRow(failures))
+		if failures >= endpointFailureLimit {
+			expectExec(mock, "DisableWebhookEndpoint").WithArgs(endpoint.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		cfg.attemptWebhookDelivery(t.Context(), delivery)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("After %d failures: %v", failures, err)
+		}
+	}
+	if got := received.Load(); got != 2 {
+		t.Fatalf("Expected 2 requests to the receiver, got %d", got)
+	}
+}
+
+func TestWebhookEndpointsCreateEnforcesLimit(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID := uuid.New()
+	expectQuery(mock, "CountWebhookEndpointsByUser").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(maxWebhookEndpoints))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks",
+		strings.NewReader(`{"url":"https://example.com/hook","events":["chirp.created"]}`))
+	req.Header.Set("Authorization", bearer(t, userID))
+	rec := httptest.NewRecorder()
+	cfg.handlerWebhookEndpointsCreate(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected the endpoint limit to be enforced, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWebhookEndpointsCreateNeedsWebhooksScope(t *testing.T) {
+	cfg, mock := newTestConfig(t)
+	userID, clientID := uuid.New(), uuid.New()
+	create := func(scope string) int {
+		token, err := auth.MakeOAuthJWT(userID, clientID, uuid.Nil, scope, testSecretKey, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/webhooks",
+			strings.NewReader(`{"url":"https://example.com/hook","events":["chirp.created"]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerWebhookEndpointsCreate(rec, req)
+		return rec.Code
+	}
+
+	if code := create(scopeChirpsWrite); code != http.StatusForbidden {
+		t.Fatalf("Expected a token without webhooks:write to be refused, got %d", code)
+	}
+
+	expectQuery(mock, "CountWebhookEndpointsByUser").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(maxWebhookEndpoints))
+	if code := create(scopeChirpsWrite + " " + scopeWebhooksWrite); code != http.StatusConflict {
+		t.Fatalf("Expected a token with webhooks:write to get past the scope check, got %d", code)
+	}
+}
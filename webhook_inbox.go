@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mr_rambling/chirpy/internal/database"
+	"github.com/mr_rambling/chirpy/internal/tracing"
+	"github.com/mr_rambling/chirpy/internal/webhooks"
+	"log/slog"
+	"time"
+)
+
+const (
+	webhookProviderPolka = "polka"
+
+	webhookStatusPending   = "pending"
+	webhookStatusProcessed = "processed"
+	webhookStatusFailed    = "failed"
+
+	webhookMaxAttempts   = 10
+	webhookRetryInterval = 30 * time.Second
+	webhookRetryBatch    = 50
+	webhookMaxBackoff    = time.Hour
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// processWebhookEvent applies a stored event inside a transaction that also
+// marks it processed, so its side effects happen exactly once. The row is
+// locked with SKIP LOCKED; if another worker holds it, or it has already
+// been processed, this is a no-op.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, id uuid.UUID) error {
+	tx, err := cfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := database.New(tracing.WrapDB(tx))
+
+	event, err := qtx.LockWebhookEvent(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	applyErr := applyWebhookEvent(ctx, qtx, event)
+	if applyErr == nil {
+		if err := qtx.MarkWebhookEventProcessed(ctx, id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// Undo any partial side effects before recording the failure.
+	tx.Rollback()
+	err = cfg.db.MarkWebhookEventFailed(ctx, database.MarkWebhookEventFailedParams{
+		ID:            id,
+		LastError:     applyErr.Error(),
+		NextAttemptAt: time.Now().UTC().Add(webhooks.Backoff(event.Attempts+1, webhookRetryInterval, webhookMaxBackoff)),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "recording webhook event failure", "event_id", id, "error", err)
+	}
+	return applyErr
+}
+
+func applyWebhookEvent(ctx context.Context, q *database.Queries, event database.WebhookEvent) error {
+	switch event.Provider {
+	case webhookProviderPolka:
+		var payload polkaEvent
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		return applyPolkaEvent(ctx, q, payload, event.ReceivedAt)
+	default:
+		return fmt.Errorf("unknown webhook provider %q", event.Provider)
+	}
+}
+
+// runWebhookRetrier picks up events that failed or were never processed,
+// for example because the server stopped mid-request, and retries them
+// until they succeed or run out of attempts.
+func (cfg *apiConfig) runWebhookRetrier(ctx context.Context) {
+	ticker := time.NewTicker(webhookRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ids, err := cfg.db.GetDueWebhookEvents(ctx, database.GetDueWebhookEventsParams{
+			Attempts: webhookMaxAttempts,
+			Limit:    webhookRetryBatch,
+		})
+		cfg.workers.beat(workerWebhookRetrier, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "loading due webhook events", "error", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := cfg.processWebhookEvent(ctx, id); err != nil {
+				slog.ErrorContext(ctx, "processing webhook event", "event_id", id, "error", err)
+			}
+		}
+	}
+}